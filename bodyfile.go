@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A body file describes a universe and its bodies, one value per line:
+//
+//	# Jupiter and its moons            <- '#' starts a comment, on its own line or after a value
+//	units: length=km                   <- optional, before anything else (default SI)
+//	width: 4e6                         <- width of the universe
+//	G: 6.67408e-20                     <- optional G in the file's units, checked against ours
+//	softening: plummer 1e17            <- optional: none, plummer or spline, and a length
+//	boundary: wrap                     <- optional: track, remove, wrap or reflect
+//	>Io                                <- each body starts with '>' and its name (which may be empty)
+//	>"Io # the innermost moon"         <- a name may be quoted as in Go, to hold '#', quotes or spaces at its ends
+//	color: 227, 168, 87                <- red, green, blue (default white)
+//	mass: 8.9319e22                    <- required
+//	radius: 1821                       <- default 0
+//	position: 1578400, 2000000         <- required
+//	velocity: 0, -17.32 km/s           <- default 0, 0
+//	acceleration: 0, 0                 <- optional, default 0, 0
+//	softening: 100                     <- optional per-body softening length, default 0
+//
+// Keys are case-insensitive and may come in any order. Lines without a key are read in the order
+// of the original Jupiter files: width then G in the header, and color, mass, radius, position,
+// velocity and acceleration in a body, so those files still load unchanged. Blank lines are ignored.
+//
+// The units line names a unit system (si, astronomical, galactic or nbody) and/or sets the unit of
+// length, mass or time, as in "units: astronomical", "units: length=kpc time=Myr" or
+// "units: nbody length=1kpc mass=1e10Msun" (N-body units take a length and a mass, and G = 1).
+// Numbers without a unit are in the file's units; any number may also carry its own unit, as the
+// velocity above does, and is checked to have the right dimension. A unit after the second number
+// of a pair only ("3, 4 km/s") applies to both; one after the first only is an error.
+
+const bodyFileGTolerance = 1e-3 // relative difference allowed between a file's G and ours
+
+// the keyless lines of the header and of a body, in order
+var headerOrder = []string{"width", "g"}
+var bodyOrder = []string{"color", "mass", "radius", "position", "velocity", "acceleration"}
+
+// LoadBodies reads the body file at path and returns the universe it describes.
+func LoadBodies(path string) (*Universe, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	u, err := ReadBodies(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return u, nil
+}
+
+// ReadBodies reads a body file from r and returns the universe it describes, with every value
+// converted to SI units. Errors give the line they were found on.
+func ReadBodies(r io.Reader) (*Universe, error) {
+	reader := bodyReader{u: &Universe{}, units: SIUnits, seen: map[string]bool{}}
+	sc := bufio.NewScanner(r)
+	lineNumber := 0
+	for sc.Scan() {
+		lineNumber++
+		line, err := stripBodyComment(sc.Text())
+		if err == nil && line != "" {
+			err = reader.line(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := reader.finish(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+	}
+	return reader.u, nil
+}
+
+// stripBodyComment returns a line of a body file without its comment and surrounding space. The '#'
+// of a comment can't be inside the quoted name of a body, which is left quoted.
+func stripBodyComment(line string) (string, error) {
+	line = strings.TrimSpace(line)
+	start := 0
+	if name, ok := strings.CutPrefix(line, ">"); ok {
+		if name = strings.TrimLeft(name, " \t"); strings.HasPrefix(name, `"`) {
+			quoted, err := strconv.QuotedPrefix(name)
+			if err != nil {
+				return "", fmt.Errorf("bad quoted name %s", name)
+			}
+			start = len(line) - len(name) + len(quoted)
+		}
+	}
+	if i := strings.IndexByte(line[start:], '#'); i >= 0 {
+		line = line[:start+i]
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// bodyReader holds the state of ReadBodies between lines.
+type bodyReader struct {
+	u       *Universe
+	units   UnitSystem      // units of the numbers in the file that don't give their own
+	started bool            // whether any value has been read, after which units are fixed
+	star    *Star           // body being read, nil in the header
+	seen    map[string]bool // keys given so far in the header or current body
+}
+
+// line reads one line of a body file, with comments and surrounding space removed.
+func (br *bodyReader) line(line string) error {
+	if name, ok := strings.CutPrefix(line, ">"); ok {
+		if err := br.finish(); err != nil {
+			return err
+		}
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, `"`) {
+			unquoted, err := strconv.Unquote(name)
+			if err != nil {
+				return fmt.Errorf("bad quoted name %s", name)
+			}
+			name = unquoted
+		}
+		br.star = &Star{name: name, red: 255, green: 255, blue: 255}
+		br.seen = map[string]bool{}
+		return nil
+	}
+
+	key, value, keyed := strings.Cut(line, ":")
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.TrimSpace(value)
+	if !keyed {
+		// the next key of the original order not given yet
+		order := headerOrder
+		if br.star != nil {
+			order = bodyOrder
+		}
+		key, value = "", line
+		for _, k := range order {
+			if !br.seen[k] {
+				key = k
+				break
+			}
+		}
+		if key == "" {
+			return fmt.Errorf("unexpected line %q", line)
+		}
+	}
+	if br.seen[key] {
+		return fmt.Errorf("%s given twice", key)
+	}
+	br.seen[key] = true
+
+	if key == "units" {
+		if br.started {
+			return fmt.Errorf("units must come before any value")
+		}
+		return br.declareUnits(value)
+	}
+	br.started = true
+	if br.star == nil {
+		return br.header(key, value)
+	}
+	return br.body(key, value)
+}
+
+// declareUnits reads a units declaration such as "astronomical" or "nbody length=1kpc mass=1e10Msun".
+func (br *bodyReader) declareUnits(value string) error {
+	units := SIUnits
+	nbody := false
+	given := map[string]bool{}
+	for _, field := range strings.Fields(value) {
+		kind, quantity, ok := strings.Cut(field, "=")
+		if !ok {
+			if strings.EqualFold(field, "nbody") {
+				nbody = true
+				continue
+			}
+			system, known := UnitSystemByName(field)
+			if !known {
+				return fmt.Errorf("unknown unit system %q", field)
+			}
+			units = system
+			continue
+		}
+
+		var size *float64
+		var d Dimension
+		switch kind {
+		case "length":
+			size, d = &units.length, DimLength
+		case "mass":
+			size, d = &units.mass, DimMass
+		case "time":
+			size, d = &units.time, DimTime
+		default:
+			return fmt.Errorf("unknown unit kind %q", kind)
+		}
+		v, err := ParseQuantity(quantity, d, SIUnits)
+		if err != nil {
+			return err
+		}
+		*size = v
+		given[kind] = true
+	}
+
+	if nbody {
+		if !given["length"] || !given["mass"] || given["time"] {
+			return fmt.Errorf("N-body units need a length and a mass, and no time")
+		}
+		units = NBodyUnits(units.mass, units.length)
+	}
+	units.name = ""
+	br.units = units
+	return nil
+}
+
+// header reads a line of the file's header.
+func (br *bodyReader) header(key, value string) error {
+	var err error
+	switch key {
+	case "width":
+		br.u.width, err = br.quantity(value, DimLength)
+		return err
+	case "g":
+		fileG, err := br.quantity(value, DimG)
+		if err != nil {
+			return err
+		}
+		// bodies set up for a different G would not move as their author meant
+		if math.Abs(fileG-G) > bodyFileGTolerance*G {
+			return fmt.Errorf("file has G = %s (%v in SI), this code uses %v", value, fileG, G)
+		}
+		return nil
+	case "softening":
+		kernel, length, _ := strings.Cut(value, " ")
+		switch strings.ToLower(kernel) {
+		case "none":
+			br.u.softening.kernel = SofteningNone
+		case "plummer":
+			br.u.softening.kernel = SofteningPlummer
+		case "spline":
+			br.u.softening.kernel = SofteningSpline
+		default:
+			return fmt.Errorf("unknown softening kernel %q", kernel)
+		}
+		if strings.TrimSpace(length) == "" {
+			return nil
+		}
+		br.u.softening.length, err = br.quantity(length, DimLength)
+		return err
+	case "boundary":
+		policy, ok := boundaryNames[strings.ToLower(value)]
+		if !ok {
+			return fmt.Errorf("unknown boundary %q", value)
+		}
+		br.u.boundary = policy
+		return nil
+	}
+	return fmt.Errorf("unknown header key %q", key)
+}
+
+// body reads a line of the current body.
+func (br *bodyReader) body(key, value string) error {
+	s := br.star
+	var err error
+	switch key {
+	case "color":
+		var r, g, b uint8
+		if _, err := fmt.Sscanf(value, "%d,%d,%d", &r, &g, &b); err != nil {
+			return fmt.Errorf("color %q: want red, green, blue from 0 to 255", value)
+		}
+		s.red, s.green, s.blue = r, g, b
+	case "mass":
+		s.mass, err = br.quantity(value, DimMass)
+	case "radius":
+		s.radius, err = br.quantity(value, DimLength)
+	case "softening":
+		s.softening, err = br.quantity(value, DimLength)
+	case "position":
+		s.position, err = br.pair(value, DimLength)
+	case "velocity":
+		s.velocity, err = br.pair(value, DimVelocity)
+	case "acceleration":
+		s.acceleration, err = br.pair(value, DimAcceleration)
+	default:
+		return fmt.Errorf("unknown body key %q", key)
+	}
+	return err
+}
+
+// finish checks the header or body just read is complete, and adds the body to the universe.
+func (br *bodyReader) finish() error {
+	if br.star == nil {
+		if !br.seen["width"] {
+			return fmt.Errorf("no width before the first body")
+		}
+		return nil
+	}
+	for _, key := range []string{"mass", "position"} {
+		if !br.seen[key] {
+			return fmt.Errorf("body %q has no %s", br.star.name, key)
+		}
+	}
+	br.u.stars = append(br.u.stars, br.star)
+	return nil
+}
+
+// quantity reads one value of a body file and returns it in SI units.
+func (br *bodyReader) quantity(s string, d Dimension) (float64, error) {
+	return ParseQuantity(s, d, br.units)
+}
+
+// pair reads an "x, y" pair of a body file, such as a position or velocity, in SI units.
+// If only the second number gives a unit ("3, 4 km/s"), it applies to both; if only the first
+// does, the pair is an error, as the second number would silently be in other units.
+func (br *bodyReader) pair(s string, d Dimension) (OrderedPair, error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	if !ok {
+		return OrderedPair{}, fmt.Errorf("bad pair %q: want x, y", s)
+	}
+	xNumber, xUnit := splitQuantity(xs)
+	yNumber, yUnit := splitQuantity(ys)
+	switch {
+	case xUnit != "" && yUnit == "" && yNumber != "":
+		return OrderedPair{}, fmt.Errorf("bad pair %q: give the unit after both numbers or after the second", s)
+	case xUnit == "" && xNumber != "" && yUnit != "":
+		xs = xNumber + " " + yUnit
+	}
+	x, err := br.quantity(xs, d)
+	if err != nil {
+		return OrderedPair{}, err
+	}
+	y, err := br.quantity(ys, d)
+	if err != nil {
+		return OrderedPair{}, err
+	}
+	return OrderedPair{x, y}, nil
+}
+
+var boundaryNames = map[string]BoundaryPolicy{
+	"track":   BoundaryTrack,
+	"remove":  BoundaryRemove,
+	"wrap":    BoundaryWrap,
+	"reflect": BoundaryReflect,
+}
+
+var kernelNames = map[SofteningKernel]string{
+	SofteningNone:    "none",
+	SofteningPlummer: "plummer",
+	SofteningSpline:  "spline",
+}
+
+// SaveBodies writes a universe to path as a body file in SI units.
+func SaveBodies(path string, u *Universe) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteBodies(f, u); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteBodies writes a universe to w as a body file in SI units, giving every number exactly,
+// so ReadBodies gives back the same stars, width, softening and boundary policy.
+func WriteBodies(w io.Writer, u *Universe) error {
+	return WriteBodiesIn(w, u, SIUnits)
+}
+
+// WriteBodiesIn is WriteBodies with the numbers in the unit system us. Outside SI the conversion
+// may round the last digit of some numbers.
+func WriteBodiesIn(w io.Writer, u *Universe, us UnitSystem) error {
+	bw := bufio.NewWriter(w)
+	if us == SIUnits {
+		fmt.Fprintln(bw, "# body file, in SI units")
+	} else {
+		fmt.Fprintln(bw, "# body file")
+		fmt.Fprintf(bw, "units: length=%sm mass=%skg time=%ss\n", formatNumber(us.length), formatNumber(us.mass), formatNumber(us.time))
+	}
+	number := func(v float64, d Dimension) string {
+		return formatNumber(us.FromSI(v, d))
+	}
+	pair := func(p OrderedPair, d Dimension) string {
+		return number(p.x, d) + ", " + number(p.y, d)
+	}
+
+	fmt.Fprintf(bw, "width: %s\n", number(u.width, DimLength))
+	fmt.Fprintf(bw, "G: %s\n", formatNumber(us.G()))
+	if u.softening != (Softening{}) {
+		fmt.Fprintf(bw, "softening: %s %s\n", kernelNames[u.softening.kernel], number(u.softening.length, DimLength))
+	}
+	if u.boundary != BoundaryTrack {
+		for name, policy := range boundaryNames {
+			if policy == u.boundary {
+				fmt.Fprintf(bw, "boundary: %s\n", name)
+			}
+		}
+	}
+
+	for _, s := range u.stars {
+		fmt.Fprintf(bw, "\n>%s\n", bodyName(s.name))
+		fmt.Fprintf(bw, "color: %d, %d, %d\n", s.red, s.green, s.blue)
+		fmt.Fprintf(bw, "mass: %s\n", number(s.mass, DimMass))
+		fmt.Fprintf(bw, "radius: %s\n", number(s.radius, DimLength))
+		fmt.Fprintf(bw, "position: %s\n", pair(s.position, DimLength))
+		fmt.Fprintf(bw, "velocity: %s\n", pair(s.velocity, DimVelocity))
+		if s.acceleration != (OrderedPair{}) {
+			fmt.Fprintf(bw, "acceleration: %s\n", pair(s.acceleration, DimAcceleration))
+		}
+		if s.softening != 0 {
+			fmt.Fprintf(bw, "softening: %s\n", number(s.softening, DimLength))
+		}
+	}
+	return bw.Flush()
+}
+
+// bodyName returns a name as written in a body file: quoted if it would otherwise read back
+// differently, because it holds a '#', a quote, a line break or the like, or starts or ends with space.
+func bodyName(name string) string {
+	quoted := strconv.Quote(name)
+	if quoted[1:len(quoted)-1] != name || strings.ContainsRune(name, '#') || name != strings.TrimSpace(name) {
+		return quoted
+	}
+	return name
+}
+
+// formatNumber gives the shortest decimal form of v that reads back as exactly v.
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+)
+
+func TestBodyFileRoundTrip(t *testing.T) {
+    u, err := LoadBodies("jupiterMoons.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(u.stars) != 5 || u.stars[1].name != "Io" || u.width != 4e9 {
+        t.Fatalf("read %d bodies, second one %q, width %v", len(u.stars), u.stars[1].name, u.width)
+    }
+    if moon := u.stars[1]; moon.red != 227 || moon.green != 168 || moon.blue != 87 || moon.velocity.y != -17320 {
+        t.Errorf("Io read as %+v", *moon)
+    }
+
+    // everything a body can carry survives writing and reading back, down to the last bit
+    u.softening = Softening{kernel: SofteningSpline, length: 1.234567890123e5}
+    u.boundary = BoundaryReflect
+    u.stars[2].acceleration = OrderedPair{1.0 / 3, -2e-9}
+    u.stars[3].softening = 0.1
+    u.stars[4].name = ""
+    // names that would read as comments, keys or surrounding space are quoted
+    for _, name := range []string{"Io # moon", " Io", "Io\nEuropa", `"Io"`, `C:\moons`, ">Io", "color: 1, 2, 3"} {
+        s := CopyStar(u.stars[1])
+        s.name = name
+        u.stars = append(u.stars, s)
+    }
+    var buf bytes.Buffer
+    if err := WriteBodies(&buf, u); err != nil {
+        t.Fatal(err)
+    }
+    back, err := ReadBodies(&buf)
+    if err != nil {
+        t.Fatalf("%v in\n%s", err, buf.String())
+    }
+    if back.width != u.width || back.softening != u.softening || back.boundary != u.boundary || len(back.stars) != len(u.stars) {
+        t.Fatalf("universe read back as %+v", *back)
+    }
+    for i := range u.stars {
+        if *back.stars[i] != *u.stars[i] {
+            t.Errorf("body %d read back as %+v, want %+v", i, *back.stars[i], *u.stars[i])
+        }
+    }
+}
+
+func TestReadBodies(t *testing.T) {
+    file := `# two bodies in astronomical units
+units: length=AU mass=Msun time=yr
+width: 10   # AU
+
+>Sun
+mass: 1
+position: 5, 5
+>Earth   # keys in any order, the color left out
+velocity: 0, 6.283
+position: 6, 5
+mass: 3e-6
+radius: 4.26e-5
+`
+    u, err := ReadBodies(strings.NewReader(file))
+    if err != nil {
+        t.Fatal(err)
+    }
+    earth := u.stars[1]
+    if earth.name != "Earth" || earth.red != 255 || earth.mass != 3e-6*solarMass || earth.position.x != 6*astronomicalUnit {
+        t.Errorf("Earth read as %+v", *earth)
+    }
+    if v := earth.velocity.y; v < 29700 || v > 29900 {
+        t.Errorf("Earth's speed %v m/s, want about 29800", v)
+    }
+
+    // a unit after the second number applies to both, with or without a space before it
+    for _, velocity := range []string{"3, 4 km/s", "3, 4km/s", "3km/s, 4km/s"} {
+        u, err := ReadBodies(strings.NewReader("width: 1\n>a\nmass: 1\nposition: 0, 0\nvelocity: " + velocity + "\n"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if v := u.stars[0].velocity; v != (OrderedPair{3000, 4000}) {
+            t.Errorf("velocity %q read as %v m/s, want {3000 4000}", velocity, v)
+        }
+    }
+
+    // errors say which line is wrong
+    broken := map[string]string{
+        "width: 1\n>a\nmass: 1\n":                           "has no position",
+        "width: 1\n>a\nmass: 1\nposition: 0\n":              "line 4: bad pair",
+        "width: 1\nG: 1\n":                                  "line 2: file has G",
+        ">a\nmass: 1\nposition: 0, 0\n":                     "no width",
+        "width: 1\nunits: length=km\n":                      "line 2: units must come",
+        "width: 1\n>a\nmass: 1\nmass: 2\n":                  "mass given twice",
+        "width: 1\n>a\n1,2,3\n1\n2\n3, 4\n5, 6\n7, 8\n9\n":  "line 9: unexpected line",
+        "width: 1\n>\"Io # moon\n":                         "line 2: bad quoted name",
+        "width: 1\n>\"Io\" moon\n":                         "line 2: bad quoted name",
+        "width: 1\n>a\nmass: 1\nposition: 3 km, 4\n":       "line 4: bad pair",
+    }
+    for input, want := range broken {
+        _, err := ReadBodies(strings.NewReader(input))
+        if err == nil || !strings.Contains(err.Error(), want) {
+            t.Errorf("reading %q: got error %v, want one containing %q", input, err, want)
+        }
+    }
+}
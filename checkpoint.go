@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const checkpointMagic = "BHCK" // first four bytes of every checkpoint file
+
+const checkpointVersion = 1 // bumped whenever the layout below changes
+
+const maxCheckpointName = 1 << 16 // longest star name a checkpoint may hold, in bytes
+
+const maxCheckpointScenario = 1 << 24 // longest scenario a checkpoint may hold, in bytes
+
+// Checkpoint is everything needed to carry on a run from the middle: the universe reached so far,
+// the length of the whole run, the time step, the solver (with theta and the other tree settings)
+// and integrator, and the seed the initial conditions were drawn with, if it is known.
+// A checkpoint saved by RunScenario also holds the fingerprint of its scenario, so that it is
+// only resumed by the same scenario. The integrators keep no state between steps other than the
+// accelerations stored on the stars.
+type Checkpoint struct {
+	universe   *Universe
+	numGens    int
+	time       float64
+	solver     ForceSolver
+	integrator Integrator
+	seed       int64
+	seeded     bool   // whether seed is known
+	scenario   []byte // fingerprint of the scenario that started the run, if any
+}
+
+// SimulateCheckpointed runs a simulation from checkpoint.universe to generation checkpoint.numGens,
+// saving a checkpoint to path every `every` generations (0 never saves). visit is called as in
+// SimulateInPlace, including for the starting universe only if it is generation 0, so a resumed run
+// visits exactly the generations the interrupted one had not reached yet.
+// A BarnesHutSolver reusing its tree rebuilds it after every checkpoint, as a resumed run has to,
+// so resuming gives results identical to a run that was never interrupted.
+func SimulateCheckpointed(checkpoint Checkpoint, stride, every int, path string, visit func(*Universe)) error {
+	if stride < 1 {
+		stride = 1
+	}
+	db := NewDoubleBuffer(checkpoint.universe)
+	u := db.Current()
+	if u.step == 0 {
+		visit(u)
+	}
+	for u.step < checkpoint.numGens {
+		u = db.Step(checkpoint.time, checkpoint.solver, checkpoint.integrator)
+		if u.step%stride == 0 {
+			visit(u)
+		}
+		if every > 0 && u.step%every == 0 && u.step < checkpoint.numGens {
+			checkpoint.universe = u
+			if err := SaveCheckpoint(path, checkpoint); err != nil {
+				return err
+			}
+			if solver := treeSolver(checkpoint.solver); solver != nil {
+				solver.sinceBuild = 0
+			}
+		}
+	}
+	return nil
+}
+
+// Resume loads the checkpoint at path and carries the run on to the end, checkpointing to the same
+// path as before. stride, every and visit are as in SimulateCheckpointed.
+func Resume(path string, stride, every int, visit func(*Universe)) error {
+	checkpoint, err := LoadCheckpoint(path)
+	if err != nil {
+		return err
+	}
+	return SimulateCheckpointed(checkpoint, stride, every, path, visit)
+}
+
+// SaveCheckpoint writes a checkpoint to path. It writes a temporary file first and renames it over
+// path, so a crash while saving leaves the previous checkpoint intact.
+func SaveCheckpoint(path string, checkpoint Checkpoint) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = WriteCheckpoint(w, checkpoint)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadCheckpoint reads the checkpoint saved at path.
+func LoadCheckpoint(path string) (Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	defer f.Close()
+	checkpoint, err := ReadCheckpoint(bufio.NewReader(f))
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return checkpoint, nil
+}
+
+// solver and integrator kinds as stored in a checkpoint
+const (
+	checkpointDirectSolver byte = iota
+	checkpointBarnesHutSolver
+	checkpointHaloSolver
+)
+
+var checkpointIntegrators = []Integrator{
+	VerletIntegrator{}, KDKIntegrator{}, DKDIntegrator{}, YoshidaIntegrator{}, RK4Integrator{}, EulerIntegrator{},
+}
+
+// WriteCheckpoint writes a checkpoint to w in the binary checkpoint format: the magic string and
+// version, then the run settings, then the universe. All numbers are little-endian.
+func WriteCheckpoint(w io.Writer, checkpoint Checkpoint) error {
+	e := &checkpointEncoder{w: w}
+	_, e.err = io.WriteString(w, checkpointMagic)
+	e.int(checkpointVersion)
+
+	e.int(checkpoint.numGens)
+	e.float(checkpoint.time)
+	e.int(int(checkpoint.seed))
+	e.bool(checkpoint.seeded)
+	e.bytes(checkpoint.scenario)
+	e.solver(checkpoint.solver)
+	if e.err != nil {
+		return e.err
+	}
+
+	kind := -1
+	for i, integrator := range checkpointIntegrators {
+		if integrator == checkpoint.integrator {
+			kind = i
+		}
+	}
+	if kind < 0 {
+		return fmt.Errorf("checkpoint: can't save integrator of type %T", checkpoint.integrator)
+	}
+	e.byte(byte(kind))
+
+	u := checkpoint.universe
+	e.float(u.width)
+	e.int(int(u.boundary))
+	e.int(int(u.softening.kernel))
+	e.float(u.softening.length)
+	e.int(u.step)
+	e.int(u.numEscaped)
+	e.int(u.collapsedLeaves)
+	e.int(len(u.stars))
+	for _, s := range u.stars {
+		e.star(s)
+	}
+	escaped := u.Escaped()
+	e.int(len(escaped))
+	for _, escaped := range escaped {
+		e.star(escaped.star)
+		e.int(escaped.step)
+	}
+	return e.err
+}
+
+// ReadCheckpoint reads a checkpoint written by WriteCheckpoint. It returns an error if r doesn't
+// hold a checkpoint, or holds one of a version this code can't read.
+func ReadCheckpoint(r io.Reader) (Checkpoint, error) {
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != checkpointMagic {
+		return Checkpoint{}, errors.New("not a checkpoint file")
+	}
+	d := &checkpointDecoder{r: r}
+	if version := d.int(); d.err == nil && version != checkpointVersion {
+		return Checkpoint{}, fmt.Errorf("checkpoint version %d, want %d", version, checkpointVersion)
+	}
+
+	var checkpoint Checkpoint
+	checkpoint.numGens = d.int()
+	checkpoint.time = d.float()
+	checkpoint.seed = int64(d.int())
+	checkpoint.seeded = d.bool()
+	checkpoint.scenario = d.bytes(maxCheckpointScenario)
+	checkpoint.solver = d.solver()
+	if d.err != nil {
+		return Checkpoint{}, fmt.Errorf("checkpoint: %w", d.err)
+	}
+
+	kind := int(d.byte())
+	if d.err == nil && kind >= len(checkpointIntegrators) {
+		return Checkpoint{}, fmt.Errorf("checkpoint: unknown integrator kind %d", kind)
+	}
+	if d.err == nil {
+		checkpoint.integrator = checkpointIntegrators[kind]
+	}
+
+	u := &Universe{}
+	u.width = d.float()
+	u.boundary = BoundaryPolicy(d.int())
+	u.softening.kernel = SofteningKernel(d.int())
+	u.softening.length = d.float()
+	u.step = d.int()
+	u.numEscaped = d.int()
+	u.collapsedLeaves = d.int()
+	// lists grow as they are read, so a corrupt length can't make us allocate a huge slice
+	for n := d.count(); len(u.stars) < n && d.err == nil; {
+		u.stars = append(u.stars, d.star())
+	}
+	for n, read := d.count(), 0; read < n && d.err == nil; read++ {
+		u.escaped = &EscapedStar{star: d.star(), step: d.int(), previous: u.escaped}
+	}
+	checkpoint.universe = u
+
+	if d.err != nil {
+		return Checkpoint{}, fmt.Errorf("checkpoint: %w", d.err)
+	}
+	return checkpoint, nil
+}
+
+// checkpointEncoder writes the fields of a checkpoint, remembering the first error.
+type checkpointEncoder struct {
+	w   io.Writer
+	buf [8]byte
+	err error
+}
+
+func (e *checkpointEncoder) uint64(v uint64) {
+	if e.err != nil {
+		return
+	}
+	binary.LittleEndian.PutUint64(e.buf[:], v)
+	_, e.err = e.w.Write(e.buf[:])
+}
+
+func (e *checkpointEncoder) int(v int)       { e.uint64(uint64(v)) }
+func (e *checkpointEncoder) float(v float64) { e.uint64(math.Float64bits(v)) }
+
+func (e *checkpointEncoder) byte(v byte) {
+	if e.err != nil {
+		return
+	}
+	e.buf[0] = v
+	_, e.err = e.w.Write(e.buf[:1])
+}
+
+func (e *checkpointEncoder) bool(v bool) {
+	if v {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+// bytes writes a length and then that many bytes.
+func (e *checkpointEncoder) bytes(b []byte) {
+	e.int(len(b))
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+// solver writes the kind of a solver and its settings. A HaloSolver's halos come before the
+// solver it wraps.
+func (e *checkpointEncoder) solver(solver ForceSolver) {
+	switch solver := solver.(type) {
+	case DirectSolver:
+		e.byte(checkpointDirectSolver)
+		e.int(solver.workers)
+	case *BarnesHutSolver:
+		e.byte(checkpointBarnesHutSolver)
+		e.float(solver.theta)
+		e.int(solver.leafCapacity)
+		e.bool(solver.quadrupole)
+		e.int(int(solver.criterion))
+		e.float(solver.tolerance)
+		e.int(solver.workers)
+		e.int(solver.buildDepth)
+		e.int(int(solver.builder))
+		e.int(solver.reuseSteps)
+		e.float(solver.refitTolerance)
+	case HaloSolver:
+		e.byte(checkpointHaloSolver)
+		e.int(len(solver.halos))
+		for _, h := range solver.halos {
+			e.float(h.center.x)
+			e.float(h.center.y)
+			e.float(h.mass)
+			e.float(h.scale)
+		}
+		e.solver(solver.solver)
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("checkpoint: can't save solver of type %T", solver)
+		}
+	}
+}
+
+func (e *checkpointEncoder) star(s *Star) {
+	if len(s.name) > maxCheckpointName && e.err == nil {
+		e.err = fmt.Errorf("star name of %d bytes is too long for a checkpoint", len(s.name))
+	}
+	e.bytes([]byte(s.name))
+	e.float(s.position.x)
+	e.float(s.position.y)
+	e.float(s.velocity.x)
+	e.float(s.velocity.y)
+	e.float(s.acceleration.x)
+	e.float(s.acceleration.y)
+	e.float(s.mass)
+	e.float(s.radius)
+	e.float(s.softening)
+	e.byte(s.red)
+	e.byte(s.green)
+	e.byte(s.blue)
+}
+
+// checkpointDecoder reads the fields of a checkpoint, remembering the first error;
+// after an error every read returns zero.
+type checkpointDecoder struct {
+	r   io.Reader
+	buf [8]byte
+	err error
+}
+
+func (d *checkpointDecoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if _, d.err = io.ReadFull(d.r, d.buf[:]); d.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(d.buf[:])
+}
+
+func (d *checkpointDecoder) int() int       { return int(d.uint64()) }
+func (d *checkpointDecoder) float() float64 { return math.Float64frombits(d.uint64()) }
+
+func (d *checkpointDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if _, d.err = io.ReadFull(d.r, d.buf[:1]); d.err != nil {
+		return 0
+	}
+	return d.buf[0]
+}
+
+func (d *checkpointDecoder) bool() bool { return d.byte() != 0 }
+
+// count reads the length of a list, refusing negative lengths.
+func (d *checkpointDecoder) count() int {
+	n := d.int()
+	if n < 0 {
+		if d.err == nil {
+			d.err = fmt.Errorf("bad list length %d", n)
+		}
+		return 0
+	}
+	return n
+}
+
+// bytes reads a length and then that many bytes, refusing lengths over limit.
+func (d *checkpointDecoder) bytes(limit int) []byte {
+	n := d.count()
+	if n > limit && d.err == nil {
+		d.err = fmt.Errorf("string of %d bytes, over the limit of %d", n, limit)
+	}
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, d.err = io.ReadFull(d.r, b); d.err != nil {
+		return nil
+	}
+	return b
+}
+
+// solver reads a solver written by checkpointEncoder.solver.
+func (d *checkpointDecoder) solver() ForceSolver {
+	switch kind := d.byte(); kind {
+	case checkpointDirectSolver:
+		return DirectSolver{workers: d.int()}
+	case checkpointBarnesHutSolver:
+		solver := &BarnesHutSolver{}
+		solver.theta = d.float()
+		solver.leafCapacity = d.int()
+		solver.quadrupole = d.bool()
+		solver.criterion = OpeningCriterion(d.int())
+		solver.tolerance = d.float()
+		solver.workers = d.int()
+		solver.buildDepth = d.int()
+		solver.builder = TreeBuilder(d.int())
+		solver.reuseSteps = d.int()
+		solver.refitTolerance = d.float()
+		return solver
+	case checkpointHaloSolver:
+		var solver HaloSolver
+		for n := d.count(); len(solver.halos) < n && d.err == nil; {
+			solver.halos = append(solver.halos, Halo{center: OrderedPair{d.float(), d.float()}, mass: d.float(), scale: d.float()})
+		}
+		solver.solver = d.solver()
+		return solver
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown solver kind %d", kind)
+		}
+		return nil
+	}
+}
+
+func (d *checkpointDecoder) star() *Star {
+	var s Star
+	s.name = string(d.bytes(maxCheckpointName))
+	s.position.x, s.position.y = d.float(), d.float()
+	s.velocity.x, s.velocity.y = d.float(), d.float()
+	s.acceleration.x, s.acceleration.y = d.float(), d.float()
+	s.mass, s.radius, s.softening = d.float(), d.float(), d.float()
+	s.red, s.green, s.blue = d.byte(), d.byte(), d.byte()
+	return &s
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "bytes"
+    "path/filepath"
+    "strconv"
+    "testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+    initial := randomUniverse(300, 1e18, 5)
+    for i, s := range initial.stars {
+        s.velocity = OrderedPair{float64(i%7) * 1e6, float64(i%5) * -1e3}
+        s.softening = float64(i%3) * 1e15
+        s.name = "star " + strconv.Itoa(i)
+    }
+    initial.softening = Softening{kernel: SofteningSpline, length: 1e16}
+    initial.boundary = BoundaryTrack // the fast stars leave, so the escape record is saved too
+
+    // a halo around a tree that is reused between steps, both of which must survive the checkpoint
+    halo := Halo{center: OrderedPair{5e17, 5e17}, mass: 1e40, scale: 1e17}
+    tree := &BarnesHutSolver{theta: 0.7, leafCapacity: 4, quadrupole: true, builder: TreeBuilderMorton, reuseSteps: 3, refitTolerance: 0.5}
+    path := filepath.Join(t.TempDir(), "run.ckpt")
+    run := Checkpoint{universe: initial, numGens: 40, time: 1e10, solver: HaloSolver{solver: tree, halos: []Halo{halo}}, integrator: KDKIntegrator{}, seed: 42, seeded: true}
+
+    // the uninterrupted run, saving every 15 generations; the last save is generation 30
+    var want []*Universe
+    if err := SimulateCheckpointed(run, 5, 15, path, func(u *Universe) { want = append(want, CopyUniverse(u)) }); err != nil {
+        t.Fatal(err)
+    }
+
+    saved, err := LoadCheckpoint(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if saved.universe.step != 30 || saved.seed != 42 || !saved.seeded || saved.numGens != 40 || saved.time != 1e10 {
+        t.Fatalf("checkpoint holds step %d, seed %d, %d generations of %v", saved.universe.step, saved.seed, saved.numGens, saved.time)
+    }
+    if solver, ok := saved.solver.(HaloSolver); !ok || len(solver.halos) != 1 || solver.halos[0] != halo || treeSolver(solver).reuseSteps != 3 {
+        t.Fatalf("checkpoint holds solver %+v", saved.solver)
+    }
+
+    var got []*Universe
+    if err := Resume(path, 5, 15, func(u *Universe) { got = append(got, CopyUniverse(u)) }); err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 2 || got[0].escaped == nil {
+        t.Fatalf("resumed run visited %d generations, want 2 (35 and 40) with escaped stars", len(got))
+    }
+    for k, u := range got {
+        w := want[len(want)-2+k]
+        if u.step != w.step || len(u.stars) != len(w.stars) || len(u.Escaped()) != len(w.Escaped()) {
+            t.Fatalf("generation %d: resumed universe doesn't match", w.step)
+        }
+        for i := range u.stars {
+            if *u.stars[i] != *w.stars[i] {
+                t.Fatalf("generation %d, star %d: resumed %v, uninterrupted %v", w.step, i, *u.stars[i], *w.stars[i])
+            }
+        }
+    }
+}
+
+func TestReadCheckpointErrors(t *testing.T) {
+    var buf bytes.Buffer
+    run := Checkpoint{universe: randomUniverse(3, 1, 1), numGens: 1, time: 1, solver: DirectSolver{}, integrator: RK4Integrator{}}
+    if err := WriteCheckpoint(&buf, run); err != nil {
+        t.Fatal(err)
+    }
+    data := buf.Bytes()
+    if _, err := ReadCheckpoint(bytes.NewReader(data)); err != nil {
+        t.Fatalf("reading a good checkpoint: %v", err)
+    }
+
+    future := bytes.Clone(data)
+    future[4] = checkpointVersion + 1
+    broken := map[string][]byte{
+        "wrong magic":   append([]byte("GIF8"), data[4:]...),
+        "newer version": future,
+        "truncated":     data[:len(data)-1],
+    }
+    for name, b := range broken {
+        if _, err := ReadCheckpoint(bytes.NewReader(b)); err == nil {
+            t.Errorf("%s: no error", name)
+        }
+    }
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrUsage is returned by RunCommand when its command line can't be parsed. By then the flag set
+// has already said what is wrong and printed the command's usage.
+var ErrUsage = errors.New("bad usage")
+
+// commandHelp is the description printed above the flags of each built-in command.
+var commandHelp = map[string]string{
+	"jupiter":   "Simulates Jupiter and its four Galilean moons, read from a body file.",
+	"galaxy":    "Simulates a single spinning galaxy around its central black hole.",
+	"collision": "Simulates two galaxies pushed towards each other.",
+}
+
+// commandFlags holds the flags of a built-in command. The scenario fills in everything that isn't set.
+type commandFlags struct {
+	scenario      string
+	fresh         bool
+	generations   int
+	dt            string
+	width         string
+	theta         float64
+	stars         int
+	seed          int64
+	canvasWidth   int
+	stride        int
+	scalingFactor float64
+	input         string
+	output        string
+}
+
+// RunCommand takes as input the name of a built-in command (jupiter, galaxy or collision), its
+// command-line arguments, and where to write usage messages. It loads the command's scenario file,
+// overrides the settings given by flags, and runs it. It returns flag.ErrHelp if help was asked for.
+func RunCommand(command string, args []string, stderr io.Writer) error {
+	help, ok := commandHelp[command]
+	if !ok {
+		return fmt.Errorf("unknown command %q", command)
+	}
+	var f commandFlags
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: go run . %s [flags]\n\n%s\nFlags left out take their value from the scenario file. A run with the same settings as an\ninterrupted one resumes from its checkpoint, if the scenario saves one.\n\nFlags:\n", command, help)
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&f.scenario, "scenario", BuiltInScenario(command), "scenario `file` to start from")
+	fs.BoolVar(&f.fresh, "fresh", false, "delete the scenario's checkpoint, if any, instead of resuming from it")
+	fs.IntVar(&f.generations, "generations", 0, "number of generations to simulate")
+	fs.StringVar(&f.dt, "dt", "", "time step, in seconds or with a unit, e.g. \"2e16\" or \"1 Myr\"")
+	fs.StringVar(&f.width, "width", "", "width of the universe, in meters or with a unit, e.g. \"100 kpc\"")
+	if command == "jupiter" {
+		fs.StringVar(&f.input, "input", "", "body `file` to load instead of the scenario's bodies")
+	} else {
+		fs.Float64Var(&f.theta, "theta", 0, "Barnes-Hut opening angle")
+		fs.IntVar(&f.stars, "stars", 0, "number of stars in each galaxy")
+		fs.Int64Var(&f.seed, "seed", 0, "seed of the galaxies' random layout")
+	}
+	fs.IntVar(&f.canvasWidth, "canvas", 0, "width of the GIF in pixels")
+	fs.IntVar(&f.stride, "stride", 0, "number of generations between frames of the GIF")
+	fs.Float64Var(&f.scalingFactor, "scale", 0, "scaling factor of the drawn stars")
+	fs.StringVar(&f.output, "output", "", "`name` of the GIF, written to name.gif")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return ErrUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return ErrUsage
+	}
+
+	s, err := LoadScenario(f.scenario)
+	if err != nil {
+		return err
+	}
+	if err := f.apply(fs, s); err != nil {
+		return err
+	}
+	if f.fresh && s.Output.Checkpoint != "" {
+		if err := os.Remove(s.Output.Checkpoint); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := RunScenario(s); err != nil {
+		if errors.Is(err, ErrCheckpointMismatch) {
+			err = fmt.Errorf("%w, or run with -fresh", err)
+		}
+		return fmt.Errorf("%s: %w", f.scenario, err)
+	}
+	return nil
+}
+
+// RunCollision3 runs the collision3d command with its command-line arguments, writing usage messages
+// to stderr. Its only flag is the seed of the galaxies, drawn afresh if left out.
+// It returns flag.ErrHelp if help was asked for.
+func RunCollision3(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("collision3d", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: go run . collision3d [flags]\n\nSimulates two tilted galaxies colliding in 3D.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	seed := fs.Int64("seed", 0, "seed of the galaxies' random layout (default a random one)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return ErrUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return ErrUsage
+	}
+	seeded := false
+	fs.Visit(func(fl *flag.Flag) { seeded = seeded || fl.Name == "seed" })
+	if !seeded {
+		*seed = rand.Int63()
+	}
+	GenerateCollision3(*seed)
+	return nil
+}
+
+// apply checks the flags set on fs and overrides the matching settings of s.
+func (f *commandFlags) apply(fs *flag.FlagSet, s *Scenario) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		if err != nil {
+			return
+		}
+		switch fl.Name {
+		case "generations":
+			if f.generations < 1 {
+				err = fmt.Errorf("-generations: want at least 1, got %d", f.generations)
+			}
+			s.Simulation.Generations = f.generations
+		case "dt":
+			var dt Quantity
+			if dt, err = siQuantity(f.dt, DimTime, "s"); err != nil {
+				err = fmt.Errorf("-dt: %w", err)
+			}
+			s.Simulation.Dt = dt
+		case "width":
+			var width Quantity
+			if width, err = siQuantity(f.width, DimLength, "m"); err != nil {
+				err = fmt.Errorf("-width: %w", err)
+			}
+			s.Width = &width
+		case "theta":
+			if f.theta < 0 {
+				err = fmt.Errorf("-theta: want 0 or more, got %v", f.theta)
+			}
+			s.Simulation.Theta = &f.theta
+		case "stars":
+			if f.stars < 1 {
+				err = fmt.Errorf("-stars: want at least 1, got %d", f.stars)
+			}
+			for _, source := range s.Bodies {
+				if source.Galaxy != nil {
+					source.Galaxy.Stars = f.stars
+				}
+				if source.Disk != nil {
+					source.Disk.Stars = f.stars
+				}
+			}
+		case "seed":
+			s.Seed = &f.seed
+		case "canvas":
+			if f.canvasWidth < 1 {
+				err = fmt.Errorf("-canvas: want at least 1 pixel, got %d", f.canvasWidth)
+			}
+			s.Output.CanvasWidth = f.canvasWidth
+		case "stride":
+			if f.stride < 1 {
+				err = fmt.Errorf("-stride: want at least 1, got %d", f.stride)
+			}
+			s.Output.Stride, s.Output.Frequency = f.stride, 1
+		case "scale":
+			if f.scalingFactor <= 0 {
+				err = fmt.Errorf("-scale: want a positive scaling factor, got %v", f.scalingFactor)
+			}
+			s.Output.ScalingFactor = f.scalingFactor
+		case "input":
+			// relative to the working directory rather than to the scenario file
+			var path string
+			if path, err = filepath.Abs(f.input); err == nil {
+				s.Bodies = []BodySource{{File: path}}
+			}
+		case "output":
+			if f.output == "" {
+				err = errors.New("-output: want a name")
+			}
+			s.Output.GIF = f.output
+		}
+	})
+	return err
+}
+
+// siQuantity reads a quantity given on the command line, where bare numbers are in SI units
+// whatever the scenario's units, and returns it with the SI unit siUnit spelled out.
+func siQuantity(text string, d Dimension, siUnit string) (Quantity, error) {
+	v, err := ParseQuantity(text, d, SIUnits)
+	if err != nil {
+		return Quantity{}, err
+	}
+	return Quantity{strconv.FormatFloat(v, 'g', -1, 64) + " " + siUnit}, nil
+}
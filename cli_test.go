@@ -0,0 +1,109 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "flag"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestRunCommandUsage(t *testing.T) {
+    var out bytes.Buffer
+    if err := RunCommand("galaxy", []string{"-help"}, &out); !errors.Is(err, flag.ErrHelp) {
+        t.Errorf("galaxy -help: got %v, want flag.ErrHelp", err)
+    }
+    for _, want := range []string{"Usage: go run . galaxy", "-theta", "-seed", "-stride"} {
+        if !strings.Contains(out.String(), want) {
+            t.Errorf("galaxy help doesn't mention %q:\n%s", want, out.String())
+        }
+    }
+    out.Reset()
+    RunCommand("jupiter", []string{"-h"}, &out)
+    if !strings.Contains(out.String(), "-input") || strings.Contains(out.String(), "-theta") {
+        t.Errorf("jupiter help should offer -input and not -theta:\n%s", out.String())
+    }
+
+    out.Reset()
+    if err := RunCollision3([]string{"-help"}, &out); !errors.Is(err, flag.ErrHelp) || !strings.Contains(out.String(), "-seed") {
+        t.Errorf("collision3d -help: got %v, want flag.ErrHelp and help offering -seed:\n%s", err, out.String())
+    }
+    if err := RunCollision3([]string{"-seed", "x"}, &bytes.Buffer{}); !errors.Is(err, ErrUsage) {
+        t.Errorf("collision3d -seed x: got %v, want ErrUsage", err)
+    }
+
+    tests := []struct {
+        args []string
+        want string
+    }{
+        {[]string{"-generations", "ten"}, "bad usage"},
+        {[]string{"-theta", "0.5", "extra"}, "bad usage"},
+        {[]string{"-generations", "0"}, "-generations: want at least 1"},
+        {[]string{"-dt", "3 km"}, "-dt: quantity \"3 km\" has dimension length"},
+        {[]string{"-theta", "-1"}, "-theta: want 0 or more"},
+        {[]string{"-stars", "0"}, "-stars: want at least 1"},
+        {[]string{"-scale", "0"}, "-scale: want a positive"},
+        {[]string{"-scenario", "nowhere.json"}, "nowhere.json"},
+    }
+    for _, test := range tests {
+        err := RunCommand("collision", test.args, &bytes.Buffer{})
+        if err == nil || !strings.Contains(err.Error(), test.want) {
+            t.Errorf("collision %v: got error %v, want one containing %q", test.args, err, test.want)
+        }
+    }
+}
+
+func TestRunCommandFlags(t *testing.T) {
+    dir := t.TempDir()
+    trajectory := filepath.Join(dir, "trajectory.csv")
+    checkpoint := filepath.Join(dir, "galaxy.ckpt")
+    scenario := filepath.Join(dir, "galaxy.json")
+    err := os.WriteFile(scenario, []byte(`{
+        "width": 1e23,
+        "bodies": [{"galaxy": {"stars": 500, "radius": 4e21, "center": [5e22, 5e22]}}],
+        "simulation": {"generations": 40000, "dt": "2e16 s", "theta": 0.5},
+        "output": {"trajectory": "`+trajectory+`", "checkpoint": "`+checkpoint+`"}
+    }`), 0o644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    run := func(extra ...string) string {
+        args := []string{"-scenario", scenario, "-generations", "4", "-stride", "2", "-stars", "3", "-seed", "7", "-dt", "1e15"}
+        if err := RunCommand("galaxy", append(args, extra...), &bytes.Buffer{}); err != nil {
+            t.Fatal(err)
+        }
+        data, err := os.ReadFile(trajectory)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return string(data)
+    }
+    first := run()
+    // a header, then generations 0, 2 and 4 of three stars and the black hole
+    lines := strings.Split(strings.TrimSpace(first), "\n")
+    if len(lines) != 1+3*4 {
+        t.Fatalf("trajectory has %d lines, want %d", len(lines), 1+3*4)
+    }
+    if !strings.HasPrefix(lines[len(lines)-1], "4,4e+15,3,") {
+        t.Errorf("last record %q, want the black hole at step 4, 4e15 s", lines[len(lines)-1])
+    }
+    if second := run(); second != first {
+        t.Error("two runs with the same seed differ")
+    }
+
+    // a checkpoint left by another run is refused, unless -fresh deletes it
+    other := Checkpoint{universe: &Universe{width: 1}, numGens: 8, time: 1, solver: DirectSolver{}, integrator: VerletIntegrator{}}
+    if err := SaveCheckpoint(checkpoint, other); err != nil {
+        t.Fatal(err)
+    }
+    err = RunCommand("galaxy", []string{"-scenario", scenario, "-generations", "4"}, &bytes.Buffer{})
+    if !errors.Is(err, ErrCheckpointMismatch) || !strings.Contains(err.Error(), "-fresh") {
+        t.Errorf("run with another run's checkpoint: got error %v, want one suggesting -fresh", err)
+    }
+    if fresh := run("-fresh"); fresh != first {
+        t.Error("-fresh run differs from the first one")
+    }
+}
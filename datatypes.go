@@ -6,22 +6,87 @@ const solarMass = 1.989e30 // mass of sun -- don't change this!
 
 const blackHoleMass = 8e36 // mass of black hole -- don't change!
 
+const maxTreeDepth = 64 // deepest level BuildNode splits to before leftover stars share a leaf
+
+const boundingPadding = 1e-3 // fraction of the star bounding box added on each side of the root quadrant
+
 // Universe contains a slice of pointers to stars and a width parameter.
 // We conceptualize the universe as a square -- stars may go outside the universe
 // but the width dictates relative distances when drawing the universe.
 type Universe struct {
 	stars []*Star
 	width float64
+
+	boundary   BoundaryPolicy // what happens to stars that leave the [0, width] square
+	escaped    *EscapedStar   // the last star to leave the square, linked to those before it (see Escaped)
+	numEscaped int            // number of stars that left during the most recent step
+	step       int            // number of steps taken to reach this universe
+
+	collapsedLeaves int // leaves of coincident stars in the tree used for the most recent step
+
+	softening Softening // gravitational softening used for every pair of stars
+}
+
+// Softening describes how gravity is smoothed at short range so close encounters
+// don't produce huge kicks. The length is the Plummer-equivalent softening length in meters.
+type Softening struct {
+	kernel SofteningKernel
+	length float64
+}
+
+// SofteningKernel selects the shape of the softened force law.
+type SofteningKernel int
+
+const (
+	SofteningNone    SofteningKernel = iota // pure 1/d^2 gravity
+	SofteningPlummer                        // force of a Plummer sphere: d/(d^2+eps^2)^(3/2)
+	SofteningSpline                         // cubic spline kernel, exactly Newtonian beyond 2.8*eps
+)
+
+const splineSupport = 2.8 // spline kernel radius in units of the Plummer-equivalent softening length
+
+// BoundaryPolicy says what UpdateUniverse does with stars that leave the universe square.
+type BoundaryPolicy int
+
+const (
+	BoundaryTrack   BoundaryPolicy = iota // stars keep moving freely; each exit is recorded
+	BoundaryRemove                        // stars are taken out of the universe and recorded
+	BoundaryWrap                          // stars re-enter on the opposite side (periodic box)
+	BoundaryReflect                       // stars bounce off the walls
+)
+
+// EscapedStar records a star that left the universe square and the step at which it left.
+// Records are never changed once made and each links to the one made before it, so the
+// generations of a run share their escape records rather than each copying them.
+type EscapedStar struct {
+	star     *Star
+	step     int
+	previous *EscapedStar
 }
 
 // Galaxy is a potentially useful object holding a list of star positions
 type Galaxy []*Star
 
+// DiskParameters describes an exponential disk galaxy for InitializeDisk. Masses are in kg,
+// lengths in m and speeds in m/s.
+type DiskParameters struct {
+	blackHole   float64 // mass of the central black hole, 0 for none
+	diskMass    float64 // total mass of the disk's stars
+	scaleLength float64 // radius over which the disk's surface density falls by a factor e
+	truncation  float64 // radius beyond which there are no stars
+	dispersion  float64 // standard deviation of each velocity component about circular motion
+
+	haloMass  float64 // mass of the Halo around the disk's center, 0 for none
+	haloScale float64 // scale radius of the halo
+}
+
 // Star is analogous to the "Body" object from the jupiter simulations.
 type Star struct {
+	name                             string // as given in a body file; may be empty
 	position, velocity, acceleration OrderedPair
 	mass                             float64
 	radius                           float64
+	softening                        float64 // per-star softening length; 0 uses the universe's
 	red, blue, green                 uint8
 }
 
@@ -34,21 +99,114 @@ type OrderedPair struct {
 // QuadTree simply contains a pointer to the root.
 // Another way of doing this would be type QuadTree *Node
 type QuadTree struct {
-	root *Node
+	root            *Node
+	collapsedLeaves int // number of leaves holding stars that couldn't be separated
 }
 
 // Node object contains a slice of children (this could just as easily be an array of length 4).
 // A node refers to a star. Sometimes, the star will be a "dummy" star, sometimes it is a star in the
 // universe, and sometimes it is nil. Every internal node points to a dummy star.
+// A leaf holding more than one star (up to the leaf capacity, or more if they are too close
+// together to split) keeps them all in stars, and points to a dummy star for them.
+// Every node with more than one star also carries the quadrupole moment of its stars.
 type Node struct {
-	children []*Node
-	star     *Star
-	sector   Quadrant
+	children   []*Node
+	star       *Star
+	stars      []*Star
+	sector     Quadrant
+	quadrupole Quadrupole
+	bmax       float64    // distance from the center of mass to the farthest star
+	moments    [3]float64 // sums of m*r^n about the center of mass for n = 2, 3, 4
+}
+
+// Quadrupole is the traceless quadrupole tensor of a group of stars about their center of mass
+// (symmetric, so xy doubles as yx). It corrects the point-mass force of a distant group.
+type Quadrupole struct {
+	xx, xy, yy float64
+}
+
+// ForceParameters controls how CalculateNetForce walks the tree.
+type ForceParameters struct {
+	theta      float64   // open a node when sector width / distance > theta
+	softening  Softening // softening of every star-star and star-node interaction
+	quadrupole bool      // add the quadrupole correction for approximated nodes
+
+	criterion OpeningCriterion // test deciding whether a node may be approximated
+	tolerance float64          // error tolerance of CriterionSalmonWarren (m/s^2) or CriterionRelative (fraction)
 }
 
+// OpeningCriterion selects the test CalculateNetForce uses to accept a node as a single point.
+type OpeningCriterion int
+
+const (
+	CriterionGeometric    OpeningCriterion = iota // sector width / distance to center of mass <= theta
+	CriterionBoxEdge                              // sector width / distance to the sector's edge <= theta
+	CriterionSalmonWarren                         // Salmon-Warren bound on the acceleration error <= tolerance
+	CriterionRelative                             // estimated error <= tolerance * previous acceleration
+)
+
 // Quadrant is an object representing a sub-square within a larger universe.
 type Quadrant struct {
 	x     float64 //bottom left corner x coordinate
 	y     float64 //bottom left corner y coordinate
 	width float64
 }
+
+/* ------------------------------ 3D ------------------------------ */
+
+// Universe3 is the 3D counterpart of Universe. We conceptualize it as a cube of side width;
+// as in 2D, stars may leave the cube, and the width only matters when drawing.
+type Universe3 struct {
+	stars     []*Star3
+	width     float64
+	softening Softening // gravitational softening used for every pair of stars
+	step      int       // number of steps taken to reach this universe
+}
+
+// Galaxy3 is a 3D galaxy: a slice of star pointers.
+type Galaxy3 []*Star3
+
+// Star3 is the 3D counterpart of Star.
+type Star3 struct {
+	position, velocity, acceleration Vector3
+	mass                             float64
+	radius                           float64
+	red, blue, green                 uint8
+}
+
+// Vector3 represents a point or vector in space.
+type Vector3 struct {
+	x float64
+	y float64
+	z float64
+}
+
+// Octree simply contains a pointer to the root.
+type Octree struct {
+	root *Node3
+}
+
+// Node3 is a node of an Octree. It works like Node: a leaf points to its star, or to a dummy star
+// for the stars it holds, and every internal node points to a dummy star. children has length 8.
+type Node3 struct {
+	children []*Node3
+	star     *Star3
+	stars    []*Star3
+	sector   Octant
+}
+
+// Octant is a sub-cube within a larger universe.
+type Octant struct {
+	x     float64 // corner with the smallest coordinates
+	y     float64
+	z     float64
+	width float64
+}
+
+// Projection is the direction a 3D universe is viewed from when it is drawn. With both angles 0
+// we look down the z axis and see the x-y plane as the 2D code draws it; azimuth turns the
+// universe about the z axis and elevation then tips it toward the viewer (both in radians).
+type Projection struct {
+	azimuth   float64
+	elevation float64
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Diagnostics holds the conserved quantities and related measures of one Universe.
+// Energies are in joules, momentum in kg m/s and angular momentum (about the origin) in kg m^2/s.
+type Diagnostics struct {
+	step            int
+	kinetic         float64
+	potential       float64
+	total           float64
+	energyError     float64 // relative change of total energy since the first recorded step
+	momentum        OrderedPair
+	angularMomentum float64
+	centerOfMass    OrderedPair
+	comDrift        float64 // distance the center of mass has moved since the first recorded step
+	virialRatio     float64 // 2K/|W|, which is 1 for a system in virial equilibrium
+}
+
+// ComputeDiagnostics takes as input a Universe and the theta used for the potential tree walk
+// (0 gives the exact potential). It returns the diagnostics of that universe on its own,
+// so energyError and comDrift are left at zero.
+func ComputeDiagnostics(u *Universe, theta float64) Diagnostics {
+	var d Diagnostics
+	d.step = u.step
+	if len(u.stars) == 0 {
+		return d
+	}
+
+	for _, s := range u.stars {
+		d.kinetic += 0.5 * s.mass * (s.velocity.x*s.velocity.x + s.velocity.y*s.velocity.y)
+		d.momentum.x += s.mass * s.velocity.x
+		d.momentum.y += s.mass * s.velocity.y
+		d.angularMomentum += s.mass * (s.position.x*s.velocity.y - s.position.y*s.velocity.x)
+	}
+	d.potential = PotentialEnergy(u, theta)
+	d.total = d.kinetic + d.potential
+	d.centerOfMass = CenterOfMass(u.stars)
+	if d.potential != 0 {
+		d.virialRatio = 2 * d.kinetic / math.Abs(d.potential)
+	}
+
+	return d
+}
+
+// RecordDiagnostics takes as input the universes of a run and the theta for the potential walk.
+// It returns the diagnostics of every universe, with energy error and center of mass drift
+// measured against the first one.
+func RecordDiagnostics(timePoints []*Universe, theta float64) []Diagnostics {
+	diags := make([]Diagnostics, len(timePoints))
+	for i, u := range timePoints {
+		diags[i] = ComputeDiagnostics(u, theta)
+		RelativeToStart(&diags[i], diags[0])
+	}
+	return diags
+}
+
+// RelativeToStart fills in the energy error and center of mass drift of d compared to first.
+func RelativeToStart(d *Diagnostics, first Diagnostics) {
+	if first.total != 0 {
+		d.energyError = (d.total - first.total) / math.Abs(first.total)
+	}
+	d.comDrift = CalcDistance(d.centerOfMass, first.centerOfMass)
+}
+
+// PotentialEnergy takes as input a Universe and theta and returns the total gravitational
+// potential energy of its stars, using a tree walk that mirrors CalculateNetForce.
+func PotentialEnergy(u *Universe, theta float64) float64 {
+	tree := GenerateQuadTree(u, 1, 0)
+	params := ForceParameters{theta: theta, softening: u.softening}
+
+	W := 0.0
+	for _, s := range u.stars {
+		W += CalculatePotential(tree.root, s, params)
+	}
+	// every pair was counted from both ends
+	return W / 2
+}
+
+// CalculatePotential takes as input a node of the tree, a star and the tree walk parameters.
+// It returns the potential energy of the star due to the stars under node.
+func CalculatePotential(node *Node, currStar *Star, params ForceParameters) float64 {
+	if node == nil || node.star == nil || currStar == nil {
+		return 0
+	}
+
+	// a single star
+	if node.children == nil && node.stars == nil {
+		if node.star == currStar {
+			return 0
+		}
+		return CalcPotential(currStar, node.star, G, params.softening)
+	}
+
+	// a cluster, or a leaf holding several stars
+	if !ContainsStar(node.stars, currStar) && AcceptNode(node, currStar, params) {
+		phi := CalcPotential(currStar, node.star, G, params.softening)
+		if params.quadrupole {
+			phi += QuadrupolePotential(currStar, node)
+		}
+		return phi
+	}
+
+	phi := 0.0
+	if node.stars != nil {
+		for _, star := range node.stars {
+			if star != currStar {
+				phi += CalcPotential(currStar, star, G, params.softening)
+			}
+		}
+		return phi
+	}
+	for _, child := range node.children {
+		phi += CalculatePotential(child, currStar, params)
+	}
+	return phi
+}
+
+// CalcPotential takes as input two stars, the gravity constant and a softening, and returns
+// the potential energy of the pair, consistent with the force from CalcSoftenedForce.
+func CalcPotential(s1, s2 *Star, G float64, soft Softening) float64 {
+	d := CalcDistance(s1.position, s2.position)
+	eps := math.Max(SofteningLength(s1, soft), SofteningLength(s2, soft))
+	if soft.kernel == SofteningNone || eps == 0 {
+		if d == 0 {
+			return 0
+		}
+		return -G * s1.mass * s2.mass / d
+	}
+	return G * s1.mass * s2.mass * KernelPotential(d, eps, soft.kernel)
+}
+
+// KernelPotential takes as input a distance, a softening length and a kernel, and returns the
+// potential of two unit masses divided by G. Unsoftened it is -1/d.
+func KernelPotential(d, eps float64, kernel SofteningKernel) float64 {
+	switch kernel {
+	case SofteningPlummer:
+		return -1 / math.Sqrt(d*d+eps*eps)
+	case SofteningSpline:
+		h := splineSupport * eps
+		if d >= h {
+			break
+		}
+		// potential of the GADGET-2 cubic spline kernel
+		u := d / h
+		if u < 0.5 {
+			return (-2.8 + u*u*(5.333333333333+u*u*(6.4*u-9.6))) / h
+		}
+		return (-3.2 + 0.066666666667/u + u*u*(10.666666666667+u*(-16.0+u*(9.6-2.133333333333*u)))) / h
+	}
+	return -1 / d
+}
+
+// QuadrupolePotential takes as input a star and a tree node and returns the quadrupole
+// correction to the potential energy of the star due to the node, -G*m*(R.Q.R)/(2*R^5).
+func QuadrupolePotential(currStar *Star, node *Node) float64 {
+	rx := currStar.position.x - node.star.position.x
+	ry := currStar.position.y - node.star.position.y
+	r2 := rx*rx + ry*ry
+	if r2 == 0 {
+		return 0
+	}
+	q := node.quadrupole
+	rqr := q.xx*rx*rx + 2*q.xy*rx*ry + q.yy*ry*ry
+	return -G * currStar.mass * rqr / (2 * r2 * r2 * math.Sqrt(r2))
+}
+
+// WriteDiagnosticsCSV writes diagnostics to w as CSV, one row per step, with a header row.
+func WriteDiagnosticsCSV(w io.Writer, diags []Diagnostics) error {
+	_, err := fmt.Fprintln(w, "step,kinetic,potential,total,energy_error,px,py,angular_momentum,com_x,com_y,com_drift,virial_ratio")
+	if err != nil {
+		return err
+	}
+	for _, d := range diags {
+		_, err := fmt.Fprintf(w, "%d,%g,%g,%g,%g,%g,%g,%g,%g,%g,%g,%g\n",
+			d.step, d.kinetic, d.potential, d.total, d.energyError,
+			d.momentum.x, d.momentum.y, d.angularMomentum,
+			d.centerOfMass.x, d.centerOfMass.y, d.comDrift, d.virialRatio)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveDiagnostics records the diagnostics of every universe of a run and writes them as CSV to path.
+func SaveDiagnostics(timePoints []*Universe, theta float64, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteDiagnosticsCSV(f, RecordDiagnostics(timePoints, theta)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
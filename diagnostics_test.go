@@ -0,0 +1,42 @@
+package main
+
+import (
+    "bytes"
+    "math"
+    "strings"
+    "testing"
+)
+
+func TestComputeDiagnostics(t *testing.T) {
+    u, period := circularOrbit()
+    M, m, r := u.stars[0].mass, u.stars[1].mass, 1e9
+    v := u.stars[1].velocity.y
+
+    d := ComputeDiagnostics(u, 0)
+    if !almostEqual(d.potential, -G*M*m/r, 1e-6*G*M*m/r) {
+        t.Errorf("potential %v, want %v", d.potential, -G*M*m/r)
+    }
+    if !almostEqual(d.kinetic, 0.5*m*v*v, 1e-6) || !almostEqual(d.momentum.y, m*v, 1e-9) {
+        t.Errorf("kinetic %v, momentum %v", d.kinetic, d.momentum)
+    }
+    // a circular orbit is virialized
+    if !almostEqual(d.virialRatio, 1, 1e-6) {
+        t.Errorf("virial ratio %v, want 1", d.virialRatio)
+    }
+
+    // a symplectic integrator keeps the energy error bounded over an orbit
+    diags := RecordDiagnostics(Simulate(u, 200, period/200, DirectSolver{}, KDKIntegrator{}), 0)
+    for _, d := range diags {
+        if math.Abs(d.energyError) > 1e-3 {
+            t.Fatalf("step %d: energy error %v", d.step, d.energyError)
+        }
+    }
+
+    var buf bytes.Buffer
+    if err := WriteDiagnosticsCSV(&buf, diags); err != nil {
+        t.Fatal(err)
+    }
+    if lines := strings.Count(buf.String(), "\n"); lines != len(diags)+1 {
+        t.Errorf("CSV has %d lines, want %d", lines, len(diags)+1)
+    }
+}
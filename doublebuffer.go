@@ -0,0 +1,43 @@
+package main
+
+// DoubleBuffer steps a simulation back and forth between two universes it owns, so that a long
+// run doesn't allocate a new Universe and new Stars every step the way UpdateUniverse does.
+type DoubleBuffer struct {
+	current, next *Universe
+}
+
+// NewDoubleBuffer takes as input an initial universe and returns a DoubleBuffer starting from a copy
+// of it (the initial universe itself is never modified).
+func NewDoubleBuffer(initialUniverse *Universe) *DoubleBuffer {
+	db := &DoubleBuffer{current: CopyUniverse(initialUniverse), next: CopyUniverse(initialUniverse)}
+	return db
+}
+
+// Current returns the latest universe. It is overwritten two steps later, so copy it to keep it.
+func (db *DoubleBuffer) Current() *Universe {
+	return db.current
+}
+
+// Step advances the simulation one step, exactly as UpdateUniverse would, and returns the new universe.
+func (db *DoubleBuffer) Step(time float64, solver ForceSolver, integrator Integrator) *Universe {
+	UpdateUniverseInto(db.next, db.current, time, solver, integrator)
+	db.current, db.next = db.next, db.current
+	return db.current
+}
+
+// SimulateInPlace is SimulateStream using a DoubleBuffer. visit is called with the initial universe
+// and then every stride-th generation; the universe it gets is reused by the simulation, so it must
+// copy (with CopyUniverse) anything it wants to keep.
+func SimulateInPlace(initialUniverse *Universe, numGens int, time float64, solver ForceSolver, integrator Integrator, stride int, visit func(*Universe)) {
+	if stride < 1 {
+		stride = 1
+	}
+	db := NewDoubleBuffer(initialUniverse)
+	visit(db.Current())
+	for i := 1; i < numGens+1; i++ {
+		u := db.Step(time, solver, integrator)
+		if i%stride == 0 {
+			visit(u)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestDoubleBuffer(t *testing.T) {
+    integrators := []Integrator{VerletIntegrator{}, KDKIntegrator{}, DKDIntegrator{}, YoshidaIntegrator{}, RK4Integrator{}, EulerIntegrator{}}
+    for _, integrator := range integrators {
+        u := randomUniverse(200, 1e18, 9)
+        u.boundary = BoundaryReflect
+        solver := &BarnesHutSolver{theta: 0.5, leafCapacity: 4, builder: TreeBuilderMorton, workers: 1}
+
+        // same trajectory as allocating a new universe every step
+        want := Simulate(u, 20, 1e9, solver, integrator)
+        db := NewDoubleBuffer(u)
+        for i := 1; i <= 20; i++ {
+            got := db.Step(1e9, solver, integrator)
+            for j, s := range got.stars {
+                if *s != *want[i].stars[j] {
+                    t.Fatalf("%T step %d star %d: got %v, want %v", integrator, i, j, *s, *want[i].stars[j])
+                }
+            }
+        }
+
+        // once warm, stepping allocates nothing
+        allocs := testing.AllocsPerRun(20, func() {
+            db.Step(1e9, solver, integrator)
+        })
+        if allocs != 0 {
+            t.Errorf("%T: %v allocations per step", integrator, allocs)
+        }
+    }
+}
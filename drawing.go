@@ -4,8 +4,11 @@ package main
 
 import (
 	"canvas"
+	"cmp"
 	"fmt"
 	"image"
+	"math"
+	"slices"
 )
 
 //AnimateSystem takes a slice of Universe objects along with a canvas width
@@ -60,4 +63,74 @@ func (u *Universe) DrawToCanvas(canvasWidth int, scalingFactor float64) image.Im
 	}
 	// we want to return an image!
 	return c.GetImage()
-}
\ No newline at end of file
+}
+
+// AnimateSystem3 is AnimateSystem for 3D universes, drawing each one as seen from view.
+func AnimateSystem3(timePoints []*Universe3, canvasWidth, frequency int, scalingFactor float64, view Projection) []image.Image {
+	if len(timePoints) == 0 {
+		panic("Error: no Universe objects present in AnimateSystem3.")
+	}
+
+	images := make([]image.Image, 0)
+	for i := range timePoints {
+		if i%frequency == 0 {
+			fmt.Println(i)
+			images = append(images, timePoints[i].DrawToCanvas(canvasWidth, scalingFactor, view))
+		}
+	}
+	return images
+}
+
+// DrawToCanvas draws a 3D universe as seen from view: its stars are projected onto the screen
+// and drawn back to front, so nearer stars cover farther ones.
+func (u *Universe3) DrawToCanvas(canvasWidth int, scalingFactor float64, view Projection) image.Image {
+	if u == nil {
+		panic("Can't Draw a nil Universe.")
+	}
+	return u.Project(view).DrawToCanvas(canvasWidth, scalingFactor)
+}
+
+// Project returns the 2D universe a 3D universe looks like from view: each star keeps its mass,
+// radius and color, its position becomes its place on the screen (the cube's center stays at the
+// center of the square), and the stars are ordered from farthest to nearest.
+func (u *Universe3) Project(view Projection) *Universe {
+	cosA, sinA := math.Cos(view.azimuth), math.Sin(view.azimuth)
+	cosE, sinE := math.Cos(view.elevation), math.Sin(view.elevation)
+	half := u.width / 2
+
+	stars := make([]*Star, len(u.stars))
+	depths := make([]float64, len(u.stars))
+	for i, b := range u.stars {
+		// turn about the z axis, then tip about the screen's horizontal axis
+		x := b.position.x - half
+		y := b.position.y - half
+		z := b.position.z - half
+		x, y = x*cosA+y*sinA, -x*sinA+y*cosA
+		y, z = y*cosE+z*sinE, -y*sinE+z*cosE
+
+		stars[i] = &Star{
+			position: OrderedPair{x + half, y + half},
+			mass:     b.mass,
+			radius:   b.radius,
+			red:      b.red,
+			green:    b.green,
+			blue:     b.blue,
+		}
+		depths[i] = z
+	}
+
+	// the viewer looks down from +z, so the lowest stars are drawn first
+	order := make([]int, len(stars))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(i, j int) int {
+		return cmp.Compare(depths[i], depths[j])
+	})
+
+	projected := &Universe{width: u.width, stars: make([]*Star, len(stars))}
+	for k, i := range order {
+		projected.stars[k] = stars[i]
+	}
+	return projected
+}
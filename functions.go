@@ -1,68 +1,259 @@
 // Amy Ji
 package main
 
-import ("math")
+import (
+	"math"
+	"slices"
+	"sync"
+)
 
 
 //BarnesHut is our highest level function.
-//Input: initial Universe object, a number of generations, and a time interval.
+//Input: initial Universe object, a number of generations, a time interval, theta, and the
+//number of stars a tree leaf may hold (larger leaves mean shallower trees but more direct sums).
 //Output: collection of Universe objects corresponding to updating the system
 //over indicated number of generations every given time interval.
-func BarnesHut(initialUniverse *Universe, numGens int, time, theta float64) []*Universe {
-	timePoints := make([]*Universe, numGens+1)
-	timePoints[0] = initialUniverse
-	for i :=1; i < numGens+1; i++{
-		u := UpdateUniverse(timePoints[i-1],time, theta)
-		timePoints[i] = u
-	}
+//Gravity is softened according to initialUniverse.softening and each star's own softening length.
+func BarnesHut(initialUniverse *Universe, numGens int, time, theta float64, leafCapacity int) []*Universe {
+	return Simulate(initialUniverse, numGens, time, &BarnesHutSolver{theta: theta, leafCapacity: leafCapacity}, VerletIntegrator{})
+}
+
+//Simulate is BarnesHut for any ForceSolver and Integrator.
+//Input: initial Universe object, a number of generations, a time interval, the solver used for forces
+//and the integrator that moves the stars.
+//Output: collection of Universe objects, one per generation.
+func Simulate(initialUniverse *Universe, numGens int, time float64, solver ForceSolver, integrator Integrator) []*Universe {
+	return SimulateSampled(initialUniverse, numGens, time, solver, integrator, 1)
+}
+
+//SimulateSampled is Simulate keeping only every stride-th generation (starting with the initial one),
+//so long runs only hold the universes that will actually be used.
+func SimulateSampled(initialUniverse *Universe, numGens int, time float64, solver ForceSolver, integrator Integrator, stride int) []*Universe {
+	timePoints := make([]*Universe, 0, numGens/max(stride, 1)+1)
+	SimulateStream(initialUniverse, numGens, time, solver, integrator, stride, func(u *Universe) {
+		timePoints = append(timePoints, u)
+	})
 	return timePoints
+}
+
+//SimulateStream runs a simulation without keeping it in memory. It calls visit with the initial
+//universe and then with every stride-th generation (generation numbers divisible by stride).
+//Only the current generation is held, so memory stays bounded however long the run is;
+//visit may keep any universe it is given, since those are never modified afterwards.
+func SimulateStream(initialUniverse *Universe, numGens int, time float64, solver ForceSolver, integrator Integrator, stride int, visit func(*Universe)) {
+	if stride < 1 {
+		stride = 1
+	}
+	u := initialUniverse
+	visit(u)
+	for i := 1; i < numGens+1; i++ {
+		u = UpdateUniverse(u, time, solver, integrator)
+		if i%stride == 0 {
+			visit(u)
+		}
+	}
+}
 
+//SimulateChannel is SimulateStream delivering the sampled universes on a channel, which is closed
+//once the run finishes. The simulation runs in its own goroutine and waits for each universe to be
+//received, so the caller must drain the channel.
+func SimulateChannel(initialUniverse *Universe, numGens int, time float64, solver ForceSolver, integrator Integrator, stride int) <-chan *Universe {
+	ch := make(chan *Universe)
+	go func() {
+		defer close(ch)
+		SimulateStream(initialUniverse, numGens, time, solver, integrator, stride, func(u *Universe) {
+			ch <- u
+		})
+	}()
+	return ch
 }
 
 // ============================ Main Functions ===============================
 
-// UpdateUniverse takes as input currentUniverse, time and theta
+// UpdateUniverse takes as input currentUniverse, time, the ForceSolver to compute gravity with
+// and the Integrator that advances the stars.
 // It returns a pointer to a new universe which has updated stars (accelerations, velocity and positions)
-func UpdateUniverse(currentUniverse *Universe, time float64, theta float64) *Universe {
+// Stars that leave the universe square are then handled according to currentUniverse.boundary.
+func UpdateUniverse(currentUniverse *Universe, time float64, solver ForceSolver, integrator Integrator) *Universe {
 	
 	newUniverse := CopyUniverse(currentUniverse)
-	tree := GenerateQuadTree(currentUniverse)
-	
-	// calculate all new accelerations
-    for i := range newUniverse.stars {
-        newUniverse.stars[i].acceleration = UpdateAcceleration(tree.root, newUniverse.stars[i], theta)
-    }
-    
-    // update velocities and positions using OLD values from currentUniverse
-    for i := range newUniverse.stars {
-        oldStar := currentUniverse.stars[i]
-        newUniverse.stars[i].velocity = UpdateVelocity(newUniverse.stars[i], oldStar.acceleration, time)
-        newUniverse.stars[i].position = UpdatePosition(newUniverse.stars[i], oldStar.acceleration, oldStar.velocity, time)
-    }
-    return newUniverse 
+	AdvanceUniverse(currentUniverse, newUniverse, time, solver, integrator)
+	return newUniverse
+}
+
+// UpdateUniverseInto is UpdateUniverse writing the new universe into newUniverse instead of a fresh
+// one. newUniverse's stars are reused, so nothing is allocated once it has as many stars as
+// currentUniverse. The two universes must not be the same.
+func UpdateUniverseInto(newUniverse, currentUniverse *Universe, time float64, solver ForceSolver, integrator Integrator) {
+	CopyUniverseInto(newUniverse, currentUniverse)
+	AdvanceUniverse(currentUniverse, newUniverse, time, solver, integrator)
+}
+
+// AdvanceUniverse takes as input a universe and a copy of it, and moves the copy forward one step:
+// the integrator updates its stars (with their old accelerations and velocities still in place),
+// then the boundary policy is applied.
+func AdvanceUniverse(currentUniverse, newUniverse *Universe, time float64, solver ForceSolver, integrator Integrator) {
+	if len(currentUniverse.stars) == 0 {
+		// every star has been removed; nothing left to move
+		newUniverse.step = currentUniverse.step + 1
+		newUniverse.numEscaped = 0
+		return
+	}
+
+	// the integrator sees the step number of the state it starts from
+	integrator.Step(newUniverse, time, solver)
+	newUniverse.step = currentUniverse.step + 1
+
+    newUniverse.numEscaped = ApplyBoundary(currentUniverse, newUniverse)
+}
+
+// ApplyBoundary takes as input a universe and the universe one step later, and applies
+// the boundary policy of newUniverse to its stars (which must line up with oldUniverse's).
+// It returns the number of stars that left the [0, width] square during the step.
+// Wrapping only moves stars: the tree forces are not periodic.
+func ApplyBoundary(oldUniverse, newUniverse *Universe) int {
+	count := 0
+	kept := newUniverse.stars[:0]
+
+	for i, s := range newUniverse.stars {
+		if InsideUniverse(newUniverse, s.position) {
+			kept = append(kept, s)
+			continue
+		}
+
+		switch newUniverse.boundary {
+		case BoundaryTrack:
+			// only count the star at the step it crosses out
+			if InsideUniverse(oldUniverse, oldUniverse.stars[i].position) {
+				count++
+				newUniverse.escaped = &EscapedStar{CopyStar(s), newUniverse.step, newUniverse.escaped}
+			}
+			kept = append(kept, s)
+		case BoundaryRemove:
+			count++
+			newUniverse.escaped = &EscapedStar{CopyStar(s), newUniverse.step, newUniverse.escaped}
+		case BoundaryWrap:
+			count++
+			s.position.x = WrapCoordinate(s.position.x, newUniverse.width)
+			s.position.y = WrapCoordinate(s.position.y, newUniverse.width)
+			kept = append(kept, s)
+		case BoundaryReflect:
+			count++
+			s.position.x, s.velocity.x = ReflectCoordinate(s.position.x, s.velocity.x, newUniverse.width)
+			s.position.y, s.velocity.y = ReflectCoordinate(s.position.y, s.velocity.y, newUniverse.width)
+			kept = append(kept, s)
+		}
+	}
+
+	// clear the tail so removed stars aren't kept alive by the backing array
+	for i := len(kept); i < len(newUniverse.stars); i++ {
+		newUniverse.stars[i] = nil
+	}
+	newUniverse.stars = kept
+
+	return count
+}
+
+// InsideUniverse returns true if the position p lies in the universe square [0, width] x [0, width].
+func InsideUniverse(u *Universe, p OrderedPair) bool {
+	return p.x >= 0 && p.x <= u.width && p.y >= 0 && p.y <= u.width
+}
+
+// WrapCoordinate takes as input a coordinate and the universe width and returns
+// the coordinate mapped periodically into [0, width).
+func WrapCoordinate(x, width float64) float64 {
+	x = math.Mod(x, width)
+	if x < 0 {
+		x += width
+	}
+	return x
+}
+
+// ReflectCoordinate takes as input a coordinate, the matching velocity component and the
+// universe width. It returns them after bouncing off whichever wall was crossed.
+func ReflectCoordinate(x, v, width float64) (float64, float64) {
+	if x < 0 {
+		x, v = -x, -v
+	} else if x > width {
+		x, v = 2*width-x, -v
+	}
+	// a star faster than one width per step could still be outside; pin it to the wall
+	return math.Min(math.Max(x, 0), width), v
+}
+
+// EscapeCounts takes as input the universes produced by BarnesHut and returns
+// the number of stars that left the universe square at each step.
+func EscapeCounts(timePoints []*Universe) []int {
+	counts := make([]int, len(timePoints))
+	for i, u := range timePoints {
+		counts[i] = u.numEscaped
+	}
+	return counts
+}
+
+// EscapedMass returns the total mass of the stars recorded as having left the universe.
+func EscapedMass(u *Universe) float64 {
+	mass := 0.0
+	for e := u.escaped; e != nil; e = e.previous {
+		mass += e.star.mass
+	}
+	return mass
 }
 
-// GenerateQuadTree takes as input a Universe object and returns a QuadTree
-func GenerateQuadTree(currentUniverse *Universe) QuadTree {
+// Escaped returns the records of the stars that have left the universe, in the order they left.
+func (u *Universe) Escaped() []EscapedStar {
+	var records []EscapedStar
+	for e := u.escaped; e != nil; e = e.previous {
+		records = append(records, *e)
+	}
+	slices.Reverse(records)
+	return records
+}
+
+// GenerateQuadTree takes as input a Universe object, a leaf capacity and a parallel depth, and returns
+// a QuadTree whose leaves hold up to leafCapacity stars each. The top parallelDepth levels of the tree
+// build their four children in separate goroutines; the tree is the same for any parallelDepth.
+// The root sector is the bounding square of the stars, so no star is ever left out of the tree.
+func GenerateQuadTree(currentUniverse *Universe, leafCapacity, parallelDepth int) QuadTree {
     if len(currentUniverse.stars) == 0 {
         panic("No stars in universe for QuadTree construction")
     }
     
-    rootQuadrant := Quadrant{0, 0, currentUniverse.width*2}
-    rootNode := BuildNode(rootQuadrant, currentUniverse.stars)
+    rootQuadrant := BoundingQuadrant(currentUniverse.stars)
+    rootNode := BuildNodeParallel(rootQuadrant, currentUniverse.stars, leafCapacity, parallelDepth)
     
     if rootNode == nil {
         panic("QuadTree root is nil - no stars were placed in tree")
     }
     
-    return QuadTree{root: rootNode}
+    return QuadTree{root: rootNode, collapsedLeaves: CountCollapsedLeaves(rootNode, leafCapacity)}
 }
 
 // BuildNode takes as input a quadrant and an array of pointers to stars
 // It first determine if there's any star in that quadrant
 // Then further seperates the stars to subquadrants until ther's no more star in subquadrants.
+// Stars that can't be separated (coincident, or closer than float64 can split) share one leaf.
 func BuildNode (quadrant Quadrant, stars []*Star) *Node {
-	starList := CountStarsInQuadrant(quadrant, stars)
+	return BuildNodeWithCapacity(quadrant, stars, 1)
+}
+
+// BuildNodeWithCapacity is BuildNode for a tree whose leaves may hold up to leafCapacity stars.
+// The stars of such a leaf are summed directly by CalculateNetForce.
+func BuildNodeWithCapacity(quadrant Quadrant, stars []*Star, leafCapacity int) *Node {
+	return BuildNodeParallel(quadrant, stars, leafCapacity, 0)
+}
+
+// BuildNodeParallel is BuildNodeWithCapacity building the four subtrees of each node in their own
+// goroutines for the top parallelDepth levels (up to 4^parallelDepth goroutines at the bottom one).
+// Every subtree is built exactly as the serial version would, so the result is identical.
+func BuildNodeParallel(quadrant Quadrant, stars []*Star, leafCapacity, parallelDepth int) *Node {
+	return buildNodeAtDepth(quadrant, CountStarsInQuadrant(quadrant, stars), 0, leafCapacity, parallelDepth)
+}
+
+// buildNodeAtDepth is BuildNodeParallel for a quadrant sitting depth levels below the root.
+// starList must already be the stars inside quadrant: below the root they come from ChildIndex,
+// and filtering them again could drop stars where rounding puts the two tests at odds.
+func buildNodeAtDepth(quadrant Quadrant, starList []*Star, depth, leafCapacity, parallelDepth int) *Node {
 	n := len(starList)
 
 	// Two base cases: no star in the quadrant or ony 1 star in the quadrant
@@ -77,6 +268,21 @@ func BuildNode (quadrant Quadrant, stars []*Star) *Node {
 		}
 	}
 
+	// Few enough stars to share a leaf, or splitting further would never separate them.
+	if n <= leafCapacity || depth >= maxTreeDepth || !CanSplitQuadrant(quadrant) {
+		dummy := ClusterStar(starList)
+		bmax, moments := ErrorMoments(starList, dummy.position)
+		return &Node{
+			children: nil,
+			star: dummy,
+			stars: starList,
+			sector: quadrant,
+			quadrupole: QuadrupoleOf(starList, dummy.position),
+			bmax: bmax,
+			moments: moments,
+		}
+	}
+
 	// If there's more than one star in this quadrant, we need to keep splitting.
 	subQuads := SplitQuadrant(quadrant)
 	// Partition all stars into four groups.
@@ -91,62 +297,86 @@ func BuildNode (quadrant Quadrant, stars []*Star) *Node {
 	
 	// Recursively build child nodes for each sub-quadrant.
 	children := make([]*Node, 4)
-	for i := 0; i < 4; i++ {
-		// for one of the quadrants, call BuildNode on that quadrant and the corresponding bucket of stars.
-		children[i] = BuildNode(subQuads[i], buckets[i])
+	if depth < parallelDepth {
+		// the subtrees share nothing, so they can be built at the same time
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				children[i] = buildNodeAtDepth(subQuads[i], buckets[i], depth+1, leafCapacity, parallelDepth)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := 0; i < 4; i++ {
+			// for one of the quadrants, call BuildNode on that quadrant and the corresponding bucket of stars.
+			children[i] = buildNodeAtDepth(subQuads[i], buckets[i], depth+1, leafCapacity, parallelDepth)
+		}
 	}
 
 	// Create dummy node for this quadrant.
-	quadMass := SumStarMasses(starList)
-	quadCom := CenterOfMass(starList)
-	dummy := &Star{
-    	position: quadCom, 
-    	mass: quadMass,
-    	radius: 0, // Dummy stars shouldn't be drawn
-    	red: 0, green: 0, blue: 0, 
-	}
+	dummy := ClusterStar(starList)
+	bmax, moments := ErrorMoments(starList, dummy.position)
 
 	// Return the internal node.
 	return &Node {
 		children : children,
 		star: dummy,
 		sector: quadrant,
+		quadrupole: CombineQuadrupoles(children, dummy.position),
+		bmax: bmax,
+		moments: moments,
 	}
 
 }
 
-// node is the root of the tree.
-func CalculateNetForce(node *Node, currStar *Star, theta float64) OrderedPair {
+// CalculateNetForce takes as input a node of the tree (normally the root), a star and the
+// parameters of the tree walk. It returns the net force exerted on the star by the stars under node.
+func CalculateNetForce(node *Node, currStar *Star, params ForceParameters) OrderedPair {
     var NetForce OrderedPair
+    soft := params.softening
     
     if node == nil || node.star == nil || currStar == nil {
         return NetForce
     }
     
     // a single star
-    if node.children == nil && node.star != nil {
+    if node.children == nil && node.stars == nil {
         if node.star == currStar {
             return NetForce
         }
-        force := CalcForce(currStar, node.star, G)
+        force := CalcSoftenedForce(currStar, node.star, G, soft)
         return force
     }
     
-    // A cluster/galaxy
-	s := node.sector.width
-	d := CalcDistance(currStar.position, node.star.position)
-
-	if d > 0 && (s/d) <= theta {
+    // A cluster/galaxy, or a leaf holding several stars
+	if !ContainsStar(node.stars, currStar) && AcceptNode(node, currStar, params) {
     	// Use the cluster approximation
-    	force := CalcForce(currStar, node.star, G)
+    	force := CalcSoftenedForce(currStar, node.star, G, soft)
+    	if params.quadrupole {
+    		q := QuadrupoleForce(currStar, node)
+    		force.x += q.x
+    		force.y += q.y
+    	}
     	return force
+	} else if node.stars != nil {
+		// Sum the stars sharing this leaf directly
+		for _, star := range node.stars {
+			if star == currStar {
+				continue
+			}
+			f := CalcSoftenedForce(currStar, star, G, soft)
+			NetForce.x += f.x
+			NetForce.y += f.y
+		}
 	} else {
     	// Otherwise, look inside this cluster
     	for _, child := range node.children {
      		if child == nil {
             	continue
         	}
-        	f := CalculateNetForce(child, currStar, theta)
+        	f := CalculateNetForce(child, currStar, params)
         	NetForce.x += f.x
         	NetForce.y += f.y
     	}
@@ -178,6 +408,59 @@ func CalcForce (s1, s2 *Star, G float64) OrderedPair {
 
 }
 
+// CalcSoftenedForce takes as input two stars, the gravity constant and a softening.
+// It returns the force exerted on star1 by star2 under the softened force law.
+// Each star uses its own softening length if it has one, and the pair uses the larger of the two.
+func CalcSoftenedForce(s1, s2 *Star, G float64, soft Softening) OrderedPair {
+	eps := math.Max(SofteningLength(s1, soft), SofteningLength(s2, soft))
+	if soft.kernel == SofteningNone || eps == 0 {
+		return CalcForce(s1, s2, G)
+	}
+
+	var Force OrderedPair
+	d := CalcDistance(s1.position, s2.position)
+	if d == 0.0 {
+		return Force
+	}
+
+	F := G * s1.mass * s2.mass * KernelFactor(d, eps, soft.kernel)
+	Force.x = F * (s2.position.x - s1.position.x)
+	Force.y = F * (s2.position.y - s1.position.y)
+
+	return Force
+}
+
+// SofteningLength returns the softening length of a star: its own if set, otherwise the universe's.
+func SofteningLength(s *Star, soft Softening) float64 {
+	if s.softening > 0 {
+		return s.softening
+	}
+	return soft.length
+}
+
+// KernelFactor takes as input a distance d > 0, a softening length and a kernel, and returns
+// the factor f such that the force between two unit masses is G*f times their separation vector.
+// For unsoftened gravity f = 1/d^3.
+func KernelFactor(d, eps float64, kernel SofteningKernel) float64 {
+	switch kernel {
+	case SofteningPlummer:
+		return 1 / math.Pow(d*d+eps*eps, 1.5)
+	case SofteningSpline:
+		h := splineSupport * eps
+		if d >= h {
+			break
+		}
+		// cubic spline of Monaghan & Lattanzio as used in GADGET-2
+		u := d / h
+		hInv3 := 1 / (h * h * h)
+		if u < 0.5 {
+			return hInv3 * (10.666666666667 + u*u*(32.0*u-38.4))
+		}
+		return hInv3 * (21.333333333333 - 48.0*u + 38.4*u*u - 10.666666666667*u*u*u - 0.066666666667/(u*u*u))
+	}
+	return 1 / (d * d * d)
+}
+
 // CalcDistance takes as input two positions and calculate the distance between them.
 func CalcDistance(p1, p2 OrderedPair) float64 {
 	// this is the distance formula from days of precalculus long ago ...
@@ -212,6 +495,50 @@ func CountStarsInQuadrant (quadrant Quadrant, stars []*Star) []*Star {
 	return starList
 }
 
+// BoundingQuadrant takes as input a slice of Star pointers and returns a square Quadrant
+// containing all of them. The square is padded on every side so that the stars with the
+// largest coordinates still fall strictly inside it (quadrants are half-open).
+func BoundingQuadrant(stars []*Star) Quadrant {
+	if len(stars) == 0 {
+		return Quadrant{}
+	}
+
+	minX, minY := stars[0].position.x, stars[0].position.y
+	maxX, maxY := minX, minY
+	for _, star := range stars {
+		minX = math.Min(minX, star.position.x)
+		minY = math.Min(minY, star.position.y)
+		maxX = math.Max(maxX, star.position.x)
+		maxY = math.Max(maxY, star.position.y)
+	}
+
+	side := math.Max(maxX-minX, maxY-minY)
+	if side == 0 {
+		// all stars sit on one point; any positive width will hold them
+		side = math.Max(math.Abs(minX), math.Abs(minY))
+		if side == 0 {
+			side = 1
+		}
+	}
+	extent := math.Max(math.Max(math.Abs(minX), math.Abs(maxX)), math.Max(math.Abs(minY), math.Abs(maxY)))
+	side = paddedSide(side, extent)
+
+	// center the square on the bounding box of the stars
+	cx := (minX + maxX) / 2
+	cy := (minY + maxY) / 2
+
+	return Quadrant{cx - side/2, cy - side/2, side}
+}
+
+// paddedSide takes as input the side of the bounding box of some stars and the largest absolute
+// value of their coordinates, and returns the side of a square padded to hold them all strictly.
+// Besides the relative padding, it adds a few units in the last place of the coordinates, which
+// rounding would otherwise eat when the coordinates are large next to the spread of the stars.
+func paddedSide(side, extent float64) float64 {
+	ulp := math.Nextafter(extent, math.Inf(1)) - extent
+	return side*(1+2*boundingPadding) + 8*ulp
+}
+
 // SplitQuadrant takes as input a Quadrant and returns a slice of four Quadrants
 // corresponding to the northwest, northeast, southwest, and southeast sub-quadrants.
 func SplitQuadrant (quadrant Quadrant) []Quadrant {
@@ -226,6 +553,196 @@ func SplitQuadrant (quadrant Quadrant) []Quadrant {
 	return subQuadrants
 }
 
+// AcceptNode takes as input a node with more than one star, a star and the tree walk parameters.
+// It returns true if the node is far enough away for the star to feel it as a single point
+// (plus quadrupole, if enabled), according to params.criterion.
+func AcceptNode(node *Node, currStar *Star, params ForceParameters) bool {
+	s := node.sector.width
+	d := CalcDistance(currStar.position, node.star.position)
+	if d == 0 {
+		return false
+	}
+
+	switch params.criterion {
+	case CriterionBoxEdge:
+		// measure to the nearest point of the sector, so a center of mass near the edge can't fool us
+		edge := DistanceToQuadrant(currStar.position, node.sector)
+		return edge > 0 && s/edge <= params.theta
+	case CriterionSalmonWarren:
+		if d <= node.bmax {
+			return false
+		}
+		return SalmonWarrenBound(node, d, params.quadrupole) <= params.tolerance
+	case CriterionRelative:
+		old := math.Hypot(currStar.acceleration.x, currStar.acceleration.y)
+		if old == 0 {
+			// no previous step to compare with, so fall back to the geometric test
+			break
+		}
+		// never accept a node the star sits in or right next to
+		center := OrderedPair{node.sector.x + s/2, node.sector.y + s/2}
+		if math.Abs(currStar.position.x-center.x) < 0.6*s && math.Abs(currStar.position.y-center.y) < 0.6*s {
+			return false
+		}
+		// estimated size of the neglected terms, compared to the star's total acceleration
+		order := 2.0
+		if params.quadrupole {
+			order = 3.0
+		}
+		return G*node.star.mass/(d*d)*math.Pow(s/d, order) <= params.tolerance*old
+	}
+
+	return s/d <= params.theta
+}
+
+// SalmonWarrenBound takes as input a node, the distance from a star to its center of mass
+// (larger than node.bmax) and whether quadrupoles are used. It returns the Salmon & Warren (1994)
+// upper bound on the acceleration error made by approximating the node.
+func SalmonWarrenBound(node *Node, d float64, quadrupole bool) float64 {
+	// the dipole about the center of mass is zero, so the first neglected order is p+1 with p = 1 or 2
+	p := 1
+	if quadrupole {
+		p = 2
+	}
+	b1 := node.moments[p-1] // sum of m*r^(p+1)
+	b2 := node.moments[p]   // sum of m*r^(p+2)
+	shrink := 1 - node.bmax/d
+
+	terms := float64(p+2)*b1/math.Pow(d, float64(p+1)) - float64(p+1)*b2/math.Pow(d, float64(p+2))
+	return G / (d * d) / (shrink * shrink) * terms
+}
+
+// ErrorMoments takes as input a slice of Star pointers and their center of mass. It returns the
+// largest distance of a star from the center, and the sums of m*r^n for n = 2, 3, 4.
+func ErrorMoments(stars []*Star, center OrderedPair) (float64, [3]float64) {
+	bmax := 0.0
+	var moments [3]float64
+	for _, star := range stars {
+		r := CalcDistance(star.position, center)
+		bmax = math.Max(bmax, r)
+		moments[0] += star.mass * r * r
+		moments[1] += star.mass * r * r * r
+		moments[2] += star.mass * r * r * r * r
+	}
+	return bmax, moments
+}
+
+// DistanceToQuadrant returns the distance from p to the nearest point of the quadrant (0 if inside).
+func DistanceToQuadrant(p OrderedPair, quadrant Quadrant) float64 {
+	dx := math.Max(math.Max(quadrant.x-p.x, 0), p.x-(quadrant.x+quadrant.width))
+	dy := math.Max(math.Max(quadrant.y-p.y, 0), p.y-(quadrant.y+quadrant.width))
+	return math.Hypot(dx, dy)
+}
+
+// QuadrupoleOf takes as input a slice of Star pointers and a point (normally their center of mass)
+// and returns their traceless quadrupole moment about that point, sum of m*(3*r*r^T - |r|^2*I).
+func QuadrupoleOf(stars []*Star, center OrderedPair) Quadrupole {
+	var q Quadrupole
+	for _, star := range stars {
+		q = AddQuadrupole(q, star.mass, star.position.x-center.x, star.position.y-center.y)
+	}
+	return q
+}
+
+// CombineQuadrupoles takes as input the children of a node and the node's center of mass, and
+// returns the node's quadrupole: each child's own moment shifted to the new center (parallel axis theorem).
+func CombineQuadrupoles(children []*Node, center OrderedPair) Quadrupole {
+	var q Quadrupole
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		q.xx += child.quadrupole.xx
+		q.xy += child.quadrupole.xy
+		q.yy += child.quadrupole.yy
+		q = AddQuadrupole(q, child.star.mass, child.star.position.x-center.x, child.star.position.y-center.y)
+	}
+	return q
+}
+
+// AddQuadrupole returns q plus the quadrupole of a point mass m at offset (dx, dy).
+// Positions lie in the plane z = 0, so |r|^2 = dx^2 + dy^2.
+func AddQuadrupole(q Quadrupole, m, dx, dy float64) Quadrupole {
+	r2 := dx*dx + dy*dy
+	q.xx += m * (3*dx*dx - r2)
+	q.xy += m * 3 * dx * dy
+	q.yy += m * (3*dy*dy - r2)
+	return q
+}
+
+// QuadrupoleForce takes as input a star and a tree node, and returns the quadrupole correction
+// to the force that node's stars exert on the star, on top of the monopole term from its center of mass.
+func QuadrupoleForce(currStar *Star, node *Node) OrderedPair {
+	var force OrderedPair
+	// R points from the node's center of mass to the star
+	rx := currStar.position.x - node.star.position.x
+	ry := currStar.position.y - node.star.position.y
+	r2 := rx*rx + ry*ry
+	if r2 == 0 {
+		return force
+	}
+	q := node.quadrupole
+	r5 := r2 * r2 * math.Sqrt(r2)
+
+	// a = G*(Q.R/R^5 - 5/2 * (R.Q.R) * R / R^7)
+	qrx := q.xx*rx + q.xy*ry
+	qry := q.xy*rx + q.yy*ry
+	rqr := rx*qrx + ry*qry
+	force.x = G * currStar.mass * (qrx - 2.5*rqr*rx/r2) / r5
+	force.y = G * currStar.mass * (qry - 2.5*rqr*ry/r2) / r5
+
+	return force
+}
+
+// CanSplitQuadrant returns false if a Quadrant is so small relative to its coordinates
+// that splitting it would give sub-quadrants no smaller than itself in float64 arithmetic.
+func CanSplitQuadrant(quadrant Quadrant) bool {
+	mid := quadrant.width / 2.0
+	return mid > 0 &&
+		quadrant.x+mid > quadrant.x && quadrant.x+mid < quadrant.x+quadrant.width &&
+		quadrant.y+mid > quadrant.y && quadrant.y+mid < quadrant.y+quadrant.width
+}
+
+// ClusterStar takes as input a slice of Star pointers and returns the dummy star standing in
+// for all of them: their total mass placed at their center of mass.
+func ClusterStar(stars []*Star) *Star {
+	return &Star{
+		position: CenterOfMass(stars),
+		mass: SumStarMasses(stars),
+		radius: 0, // Dummy stars shouldn't be drawn
+		red: 0, green: 0, blue: 0,
+	}
+}
+
+// ContainsStar returns true if s is one of the stars in the slice.
+func ContainsStar(stars []*Star, s *Star) bool {
+	for _, star := range stars {
+		if star == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CountCollapsedLeaves takes as input a node of a tree and its leaf capacity, and returns the number
+// of leaves below it holding more stars than that because they were too close together to split.
+func CountCollapsedLeaves(node *Node, leafCapacity int) int {
+	if node == nil {
+		return 0
+	}
+	if node.children == nil {
+		if len(node.stars) > leafCapacity {
+			return 1
+		}
+		return 0
+	}
+	count := 0
+	for _, child := range node.children {
+		count += CountCollapsedLeaves(child, leafCapacity)
+	}
+	return count
+}
+
 // SumStarMasses takes as input a slice of Star pointers and returns the sum of their masses.	
 func SumStarMasses (stars []*Star) float64 {
 
@@ -261,10 +778,12 @@ func CenterOfMass (stars []*Star) OrderedPair {
 	return center
 }
 
-func UpdateAcceleration(root *Node, s *Star, theta float64) OrderedPair {
+// UpdateAcceleration takes as input the root of the tree, a star and the tree walk parameters.
+// It returns the acceleration of the star due to every other star in the tree.
+func UpdateAcceleration(root *Node, s *Star, params ForceParameters) OrderedPair {
 	var accel OrderedPair 
 
-	force := CalculateNetForce(root, s , theta)
+	force := CalculateNetForce(root, s , params)
 
 	accel.x = force.x/s.mass
 	accel.y = force.y/s.mass 
@@ -272,6 +791,8 @@ func UpdateAcceleration(root *Node, s *Star, theta float64) OrderedPair {
 	return accel
 }
 
+// UpdateVelocity takes as input a star holding its new acceleration, its old acceleration and time.
+// It returns the star's new velocity, using the average of the two accelerations.
 func UpdateVelocity(s *Star, oldAcceleration OrderedPair, time float64) OrderedPair {
 	var currentVelocity OrderedPair 
 
@@ -282,6 +803,8 @@ func UpdateVelocity(s *Star, oldAcceleration OrderedPair, time float64) OrderedP
 	return currentVelocity
 }
 
+// UpdatePosition takes as input a star, its old acceleration and velocity, and time.
+// It returns the star's new position.
 func UpdatePosition(s *Star, oldAcceleration OrderedPair, oldVelocity OrderedPair, time float64) OrderedPair {
 	var pos OrderedPair 
 
@@ -292,10 +815,18 @@ func UpdatePosition(s *Star, oldAcceleration OrderedPair, oldVelocity OrderedPai
 	return pos
 }
 
+// CopyUniverse takes as input a universe and returns a pointer to a deep copy of it.
 func CopyUniverse(currentUniverse *Universe) *Universe {
 	var newUniverse Universe
 
 	newUniverse.width = currentUniverse.width
+	newUniverse.boundary = currentUniverse.boundary
+	newUniverse.softening = currentUniverse.softening
+	newUniverse.numEscaped = currentUniverse.numEscaped
+	newUniverse.step = currentUniverse.step
+
+	// escape records never change, so the two universes can share them
+	newUniverse.escaped = currentUniverse.escaped
 
 	numStars := len(currentUniverse.stars)
 
@@ -308,9 +839,39 @@ func CopyUniverse(currentUniverse *Universe) *Universe {
 	return &newUniverse
 }
 
+// CopyUniverseInto copies currentUniverse into newUniverse, overwriting newUniverse's own stars
+// rather than allocating new ones wherever it already has them.
+func CopyUniverseInto(newUniverse, currentUniverse *Universe) {
+	newUniverse.width = currentUniverse.width
+	newUniverse.boundary = currentUniverse.boundary
+	newUniverse.softening = currentUniverse.softening
+	newUniverse.numEscaped = currentUniverse.numEscaped
+	newUniverse.step = currentUniverse.step
+	newUniverse.escaped = currentUniverse.escaped
+
+	numStars := len(currentUniverse.stars)
+	if cap(newUniverse.stars) < numStars {
+		grown := make([]*Star, numStars)
+		copy(grown, newUniverse.stars)
+		newUniverse.stars = grown
+	}
+	newUniverse.stars = newUniverse.stars[:numStars]
+
+	for i, s := range currentUniverse.stars {
+		// removed stars leave nil slots behind (they now belong to the escape record)
+		if newUniverse.stars[i] == nil {
+			newUniverse.stars[i] = new(Star)
+		}
+		*newUniverse.stars[i] = *s
+	}
+}
+
+// CopyStar takes as input a star and returns a pointer to a new star with the same fields.
 func CopyStar(s *Star) *Star {
 	var s2 Star
 
+	s2.name = s.name
+
 	s2.position.x = s.position.x
 	s2.position.y = s.position.y
 
@@ -322,6 +883,7 @@ func CopyStar(s *Star) *Star {
 
 	s2.mass = s.mass
 	s2.radius = s.radius
+	s2.softening = s.softening
 
 	s2.red = s.red
 	s2.green = s.green
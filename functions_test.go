@@ -448,3 +448,198 @@ func TestUpdatePosition(t *testing.T) {
 }
 
 
+
+// === Test 9: BoundingQuadrant ===
+func TestBoundingQuadrant(t *testing.T) {
+    inputs := ReadDirectory("Tests/BoundingQuadrant/input")
+    for _, file := range inputs {
+        stars := readStars("Tests/BoundingQuadrant/input/" + file.Name())
+        minWidth := readFloat("Tests/BoundingQuadrant/output/" + file.Name())
+        got := BoundingQuadrant(stars)
+
+        if got.width < minWidth || got.width <= 0 {
+            t.Errorf("%s: width %v, want at least %v", file.Name(), got.width, minWidth)
+        }
+        // every star must land in the root so none is dropped from the tree
+        if n := len(CountStarsInQuadrant(got, stars)); n != len(stars) {
+            t.Errorf("%s: quadrant %v holds %d of %d stars", file.Name(), got, n, len(stars))
+        }
+    }
+
+    // stars close together far from the origin, where the relative padding rounds away
+    for _, xs := range [][2]float64{{5e22, 5e22 + 1.5e9}, {1e20, 1e20 + 16384}, {-1e20 - 16384, -1e20}} {
+        stars := []*Star{{position: OrderedPair{xs[0], 5e22}}, {position: OrderedPair{xs[1], 5e22}}}
+        got := BoundingQuadrant(stars)
+        if n := len(CountStarsInQuadrant(got, stars)); n != 2 {
+            t.Errorf("stars at x = %v: quadrant %v holds %d of 2", xs, got, n)
+        }
+    }
+}
+
+// === Test 10: ApplyBoundary ===
+func TestApplyBoundary(t *testing.T) {
+    policies := []BoundaryPolicy{BoundaryTrack, BoundaryRemove, BoundaryWrap, BoundaryReflect}
+    // star 0 stays inside, star 1 leaves through the right wall
+    wantStars := []int{2, 1, 2, 2}
+    for k, policy := range policies {
+        old := &Universe{width: 10, boundary: policy, stars: []*Star{
+            {position: OrderedPair{5, 5}, mass: 1},
+            {position: OrderedPair{9, 5}, velocity: OrderedPair{2, 0}, mass: 3},
+        }}
+        next := CopyUniverse(old)
+        next.step = 1
+        next.stars[1].position.x = 11
+
+        got := ApplyBoundary(old, next)
+        if got != 1 || len(next.stars) != wantStars[k] {
+            t.Errorf("policy %d: escaped %d with %d stars left, want 1 with %d", policy, got, len(next.stars), wantStars[k])
+        }
+
+        switch policy {
+        case BoundaryTrack, BoundaryRemove:
+            if escaped := next.Escaped(); len(escaped) != 1 || escaped[0].step != 1 || EscapedMass(next) != 3 {
+                t.Errorf("policy %d: escape record %v", policy, escaped)
+            }
+            if CopyUniverse(next).escaped != next.escaped {
+                t.Errorf("policy %d: a copy doesn't share the escape record", policy)
+            }
+        case BoundaryWrap:
+            if !almostEqual(next.stars[1].position.x, 1, 1e-9) {
+                t.Errorf("wrap: x = %v, want 1", next.stars[1].position.x)
+            }
+        case BoundaryReflect:
+            if !almostEqual(next.stars[1].position.x, 9, 1e-9) || next.stars[1].velocity.x != -2 {
+                t.Errorf("reflect: x = %v, vx = %v, want 9, -2", next.stars[1].position.x, next.stars[1].velocity.x)
+            }
+        }
+    }
+}
+
+// === Test 11: KernelFactor ===
+func TestKernelFactor(t *testing.T) {
+    eps := 2.0
+    // beyond the spline support the force is exactly Newtonian
+    d := splineSupport * eps * 1.5
+    if got, want := KernelFactor(d, eps, SofteningSpline), 1/(d*d*d); !almostEqual(got, want, 1e-15) {
+        t.Errorf("spline at %v: got %v, want %v", d, got, want)
+    }
+    // far away Plummer tends to Newtonian
+    d = 1e4
+    if got, want := KernelFactor(d, eps, SofteningPlummer), 1/(d*d*d); math.Abs(got-want)/want > 1e-6 {
+        t.Errorf("plummer at %v: got %v, want %v", d, got, want)
+    }
+    // close in, both kernels stay finite and weaker than 1/d^2
+    d = 1e-3
+    for _, k := range []SofteningKernel{SofteningPlummer, SofteningSpline} {
+        if got := KernelFactor(d, eps, k); math.IsInf(got, 0) || got >= 1/(d*d*d) {
+            t.Errorf("kernel %d at %v: got %v", k, d, got)
+        }
+    }
+    // the spline is continuous at its support radius
+    h := splineSupport * eps
+    if in, out := KernelFactor(h*(1-1e-9), eps, SofteningSpline), KernelFactor(h, eps, SofteningSpline); math.Abs(in-out)/out > 1e-6 {
+        t.Errorf("spline jumps at support: %v vs %v", in, out)
+    }
+}
+
+// === Test 12: CountCollapsedLeaves ===
+func TestCountCollapsedLeaves(t *testing.T) {
+    a := &Star{position: OrderedPair{3, 3}, mass: 1}
+    b := &Star{position: OrderedPair{3, 3}, mass: 1}
+    c := &Star{position: OrderedPair{7, 7}, mass: 2}
+    root := BuildNode(Quadrant{0, 0, 10}, []*Star{a, b, c})
+
+    if got := CountCollapsedLeaves(root, 1); got != 1 {
+        t.Errorf("CountCollapsedLeaves = %d, want 1", got)
+    }
+    // c feels both coincident stars; a feels only c since b sits exactly on it
+    fc := CalculateNetForce(root, c, ForceParameters{theta: 0.5})
+    want := CalcForce(c, &Star{position: OrderedPair{3, 3}, mass: 2}, G)
+    if !almostEqual(fc.x, want.x, 1e-20) || !almostEqual(fc.y, want.y, 1e-20) {
+        t.Errorf("force on c = %v, want %v", fc, want)
+    }
+    fa := CalculateNetForce(root, a, ForceParameters{theta: 0.5})
+    want = CalcForce(a, c, G)
+    if !almostEqual(fa.x, want.x, 1e-20) || !almostEqual(fa.y, want.y, 1e-20) {
+        t.Errorf("force on a = %v, want %v", fa, want)
+    }
+}
+
+// === Test 13: BuildNodeWithCapacity ===
+func TestBuildNodeWithCapacity(t *testing.T) {
+    stars := readStars("Tests/BuildNode/input/case2.txt")[1:] // drop the quadrant line
+    quadrant := Quadrant{0, 0, 100}
+    single := BuildNode(quadrant, stars)
+    bucketed := BuildNodeWithCapacity(quadrant, stars, 5)
+
+    if countLeafNodes(bucketed) >= countLeafNodes(single) {
+        t.Errorf("capacity 5 gave %d leaves, capacity 1 gave %d", countLeafNodes(bucketed), countLeafNodes(single))
+    }
+    if CountCollapsedLeaves(bucketed, 5) != 0 {
+        t.Errorf("no leaf should exceed its capacity")
+    }
+    // with theta = 0 nothing is approximated, so both trees give the exact force
+    for _, s := range stars {
+        want := CalculateNetForce(single, s, ForceParameters{})
+        got := CalculateNetForce(bucketed, s, ForceParameters{})
+        if !almostEqual(got.x, want.x, 1e-20) || !almostEqual(got.y, want.y, 1e-20) {
+            t.Errorf("force on %v: got %v, want %v", s.position, got, want)
+        }
+    }
+}
+
+// Helper: report whether two trees have the same shape, stars and moments
+func sameTree(a, b *Node) bool {
+    if a == nil || b == nil {
+        return a == b
+    }
+    if a.sector != b.sector || a.quadrupole != b.quadrupole || a.moments != b.moments || len(a.stars) != len(b.stars) {
+        return false
+    }
+    // real stars must be the same pointers; dummy stars the same values
+    if a.children == nil && a.stars == nil {
+        if a.star != b.star {
+            return false
+        }
+    } else if *a.star != *b.star {
+        return false
+    }
+    for i := range a.stars {
+        if a.stars[i] != b.stars[i] {
+            return false
+        }
+    }
+    if len(a.children) != len(b.children) {
+        return false
+    }
+    for i := range a.children {
+        if !sameTree(a.children[i], b.children[i]) {
+            return false
+        }
+    }
+    return true
+}
+
+// === Test 14: BuildNodeParallel ===
+func TestBuildNodeParallel(t *testing.T) {
+    u := randomUniverse(5000, 1e18, 5)
+    quadrant := BoundingQuadrant(u.stars)
+    serial := BuildNodeWithCapacity(quadrant, u.stars, 8)
+    for _, depth := range []int{1, 2, 4} {
+        if !sameTree(serial, BuildNodeParallel(quadrant, u.stars, 8, depth)) {
+            t.Errorf("parallel depth %d built a different tree", depth)
+        }
+    }
+}
+
+func BenchmarkBuildNodeParallel(b *testing.B) {
+    u := randomUniverse(100000, 1e18, 6)
+    quadrant := BoundingQuadrant(u.stars)
+    for _, depth := range []int{0, 1, 2, 3} {
+        b.Run(fmt.Sprintf("depth%d", depth), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                BuildNodeParallel(quadrant, u.stars, 8, depth)
+            }
+        })
+    }
+}
@@ -0,0 +1,67 @@
+package main
+
+import "math"
+
+// Halo is a static, spherical Hernquist halo of dark matter, whose density falls off as
+// 1/(r(r+a)^3) around its center. It isn't made of stars, which would be a cold disk in the plane
+// like any other and as unstable; HaloSolver adds its pull to that of the stars instead. Its mass
+// is in kg and its lengths in m.
+type Halo struct {
+	center OrderedPair
+	mass   float64 // total mass, a quarter of which lies within the scale radius
+	scale  float64 // scale radius a
+}
+
+// EnclosedMass returns the mass of the halo within a distance r of its center, M r^2/(r+a)^2.
+func (h Halo) EnclosedMass(r float64) float64 {
+	if r <= 0 {
+		return 0
+	}
+	return h.mass * r * r / ((r + h.scale) * (r + h.scale))
+}
+
+// Acceleration returns the acceleration the halo gives a star at p: G M(<r)/r^2 toward its center.
+func (h Halo) Acceleration(p OrderedPair) OrderedPair {
+	dx, dy := p.x-h.center.x, p.y-h.center.y
+	r := math.Sqrt(dx*dx + dy*dy)
+	if r == 0 {
+		return OrderedPair{}
+	}
+	k := -G * h.EnclosedMass(r) / (r * r * r)
+	return OrderedPair{k * dx, k * dy}
+}
+
+// HaloSolver is a ForceSolver adding the pull of static halos to the gravity of the stars on
+// each other, which its solver computes. The halos don't move, and the energies of
+// ComputeDiagnostics leave them out.
+type HaloSolver struct {
+	solver ForceSolver
+	halos  []Halo
+}
+
+// ComputeAccelerations fills accelerations with those of solver.solver plus the pull of every halo.
+func (solver HaloSolver) ComputeAccelerations(u *Universe, accelerations []OrderedPair) {
+	solver.solver.ComputeAccelerations(u, accelerations)
+	for i, s := range u.stars {
+		for _, h := range solver.halos {
+			a := h.Acceleration(s.position)
+			accelerations[i].x += a.x
+			accelerations[i].y += a.y
+		}
+	}
+}
+
+// treeSolver returns the BarnesHutSolver under any HaloSolvers wrapping solver, or nil if it is
+// another solver.
+func treeSolver(solver ForceSolver) *BarnesHutSolver {
+	for {
+		switch s := solver.(type) {
+		case *BarnesHutSolver:
+			return s
+		case HaloSolver:
+			solver = s.solver
+		default:
+			return nil
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"cmp"
 	"math"
 	"math/rand"
+	"slices"
 )
 
 // InitializeUniverse() sets an initial universe given a collection of galaxies and a width.
@@ -20,21 +22,22 @@ func InitializeUniverse(galaxies []Galaxy, w float64) *Universe {
 }
 
 // InitializeGalaxy takes number of stars in the galaxy, radius of the galaxy to be constructed,
-// and center of galaxy to be constructed. Returns a spinning Galaxy object -- which is just a slice of Star pointers
-func InitializeGalaxy(numOfStars int, r, x, y float64) Galaxy {
+// center of galaxy to be constructed, and the random number generator to place the stars with.
+// Returns a spinning Galaxy object -- which is just a slice of Star pointers
+func InitializeGalaxy(numOfStars int, r, x, y float64, rng *rand.Rand) Galaxy {
 	g := make(Galaxy, numOfStars)
 
 	for i := range g {
 		var s Star
 
 		// First choose distance to center of galaxy
-		dist := (rand.Float64() + 1.0) / 2.0
+		dist := (rng.Float64() + 1.0) / 2.0
 
 		// multiply by factor of r
 		dist *= r
 
 		// Next choose the angle in radians to represent the rotation
-		angle := rand.Float64() * 2 * math.Pi
+		angle := rng.Float64() * 2 * math.Pi
 
 		// convert polar coordinates to Cartesian
 		s.position.x = x + dist*math.Cos(angle)
@@ -78,3 +81,125 @@ func InitializeGalaxy(numOfStars int, r, x, y float64) Galaxy {
 
 	return g
 }
+
+// InitializeUniverse3 sets an initial 3D universe given a collection of galaxies and a width.
+// It returns a pointer to the resulting universe.
+func InitializeUniverse3(galaxies []Galaxy3, w float64) *Universe3 {
+	var u Universe3
+	u.width = w
+	for _, g := range galaxies {
+		u.stars = append(u.stars, g...)
+	}
+	return &u
+}
+
+// InitializeGalaxy3 is InitializeGalaxy in three dimensions. It takes the number of stars, the
+// radius of the galaxy, its center, two angles in radians (the inclination of the disk to the
+// x-y plane, and the angle about the z axis of the line where the disk crosses that plane) and
+// the random number generator to place the stars with.
+// Stars are laid out and spun exactly as in the 2D disk, which is then tilted into place.
+func InitializeGalaxy3(numOfStars int, r float64, center Vector3, inclination, ascendingNode float64, rng *rand.Rand) Galaxy3 {
+	g := make(Galaxy3, numOfStars)
+
+	for i := range g {
+		var s Star3
+
+		dist := r * (rng.Float64() + 1.0) / 2.0
+		angle := rng.Float64() * 2 * math.Pi
+		speed := 0.5 * math.Sqrt(G*blackHoleMass/dist) // half the orbital speed, as in 2D
+
+		offset := Vector3{dist * math.Cos(angle), dist * math.Sin(angle), 0}
+		velocity := Vector3{speed * math.Cos(angle+math.Pi/2.0), speed * math.Sin(angle+math.Pi/2.0), 0}
+		offset = TiltVector(offset, inclination, ascendingNode)
+
+		s.position = Vector3{center.x + offset.x, center.y + offset.y, center.z + offset.z}
+		s.velocity = TiltVector(velocity, inclination, ascendingNode)
+		s.mass = solarMass
+		s.radius = 696340000
+		s.red, s.green, s.blue = 255, 255, 255
+
+		g[i] = &s
+	}
+
+	// add a blackhole to the center of the galaxy
+	blackhole := Star3{
+		position: center,
+		mass:     blackHoleMass,
+		radius:   6963400000,
+		blue:     255,
+	}
+	return append(g, &blackhole)
+}
+
+// TiltVector takes as input a vector in the x-y plane and the two angles of InitializeGalaxy3.
+// It returns the vector turned by the inclination about the x axis and then by the ascending
+// node about the z axis.
+func TiltVector(v Vector3, inclination, ascendingNode float64) Vector3 {
+	cosI, sinI := math.Cos(inclination), math.Sin(inclination)
+	tilted := Vector3{v.x, v.y*cosI - v.z*sinI, v.y*sinI + v.z*cosI}
+
+	cosN, sinN := math.Cos(ascendingNode), math.Sin(ascendingNode)
+	return Vector3{tilted.x*cosN - tilted.y*sinN, tilted.x*sinN + tilted.y*cosN, tilted.z}
+}
+
+// InitializeDisk takes the number of stars in an exponential disk galaxy, its center, its
+// parameters and the random number generator to place the stars with. It returns the galaxy:
+// the disk's stars, then its black hole.
+// Every star is set on the circular orbit of the mass enclosed by its radius (the black hole,
+// the disk, as if spherically distributed, and the halo, if any), plus a random velocity drawn
+// with the given dispersion, so no speed has to be scaled down to keep the galaxy together.
+// Such orbits hold with a symplectic integrator like KDKIntegrator; VerletIntegrator lets them drift out.
+// The halo is not made of stars: the galaxy only holds together if the run adds its pull with a
+// HaloSolver.
+func InitializeDisk(numOfStars int, center OrderedPair, p DiskParameters, rng *rand.Rand) Galaxy {
+	g := make(Galaxy, 0, numOfStars+1)
+	radii := make([]float64, 0, numOfStars)
+
+	// the disk's surface density is exp(-R/h), so the fraction of its mass within R is
+	// 1-(1+R/h)exp(-R/h); invert that for a uniform draw restricted to the truncation radius
+	diskFraction := func(x float64) float64 { return 1 - (1+x)*math.Exp(-x) }
+	xMax := p.truncation / p.scaleLength
+	for i := 0; i < numOfStars; i++ {
+		target := rng.Float64() * diskFraction(xMax)
+		lo, hi := 0.0, xMax
+		for k := 0; k < 64; k++ {
+			mid := (lo + hi) / 2
+			if diskFraction(mid) < target {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		s := &Star{mass: p.diskMass / float64(numOfStars), radius: 696340000, red: 255, green: 255, blue: 255}
+		g = append(g, s)
+		radii = append(radii, lo*p.scaleLength)
+	}
+
+	// enclosed mass of each star: the black hole and every star closer to the center
+	order := make([]int, len(g))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(i, j int) int { return cmp.Compare(radii[i], radii[j]) })
+	halo := Halo{center: center, mass: p.haloMass, scale: p.haloScale}
+	enclosed := p.blackHole
+	for _, i := range order {
+		s, r := g[i], radii[i]
+		angle := rng.Float64() * 2 * math.Pi
+		s.position.x = center.x + r*math.Cos(angle)
+		s.position.y = center.y + r*math.Sin(angle)
+
+		speed := 0.0
+		if r > 0 {
+			speed = math.Sqrt(G * (enclosed + halo.EnclosedMass(r)) / r)
+		}
+		s.velocity.x = speed*math.Cos(angle+math.Pi/2.0) + p.dispersion*rng.NormFloat64()
+		s.velocity.y = speed*math.Sin(angle+math.Pi/2.0) + p.dispersion*rng.NormFloat64()
+		enclosed += s.mass
+	}
+
+	if p.blackHole > 0 {
+		g = append(g, &Star{mass: p.blackHole, position: center, blue: 255, radius: 6963400000})
+	}
+	return g
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "math"
+    "math/rand"
+    "slices"
+    "testing"
+)
+
+func TestInitializeDisk(t *testing.T) {
+    const kpc = 1e3 * parsec
+    p := DiskParameters{
+        blackHole:   blackHoleMass,
+        diskMass:    1e10 * solarMass,
+        scaleLength: 3 * kpc,
+        truncation:  15 * kpc,
+    }
+    center := OrderedPair{5e22, 5e22}
+    g := InitializeDisk(1000, center, p, rand.New(rand.NewSource(1)))
+    if len(g) != 1000+1 {
+        t.Fatalf("got %d bodies, want 1001", len(g))
+    }
+    if total := SumStarMasses(g); math.Abs(total-(p.blackHole+p.diskMass)) > 1e-12*total {
+        t.Errorf("total mass %v, want %v", total, p.blackHole+p.diskMass)
+    }
+
+    // every orbit is the circular one around the mass closer to the center
+    radius := func(s *Star) float64 { return CalcDistance(s.position, center) }
+    for _, s := range g[:len(g)-1] {
+        r := radius(s)
+        if r > p.truncation {
+            t.Fatalf("body at %v, beyond the truncation radius %v", r, p.truncation)
+        }
+        enclosed := p.blackHole
+        for _, other := range g[:len(g)-1] {
+            if radius(other) < r {
+                enclosed += other.mass
+            }
+        }
+        v2 := s.velocity.x*s.velocity.x + s.velocity.y*s.velocity.y
+        radial := (s.position.x-center.x)*s.velocity.x + (s.position.y-center.y)*s.velocity.y
+        if math.Abs(v2*r/G-enclosed) > 1e-9*enclosed || math.Abs(radial) > 1e-9*r*math.Sqrt(v2) {
+            t.Fatalf("body at %v moves at %v, not on the circular orbit of %v kg", r, math.Sqrt(v2), enclosed)
+        }
+    }
+
+    // a cold disk on circular orbits is close to virial equilibrium (InitializeGalaxy's is near
+    // 0.25); taking the enclosed mass as spherical underestimates the pull of a flat disk a little
+    soft := Softening{kernel: SofteningPlummer, length: 0.1 * kpc}
+    if ratio := ComputeDiagnostics(&Universe{stars: g, width: 1e23, softening: soft}, 0).virialRatio; math.Abs(ratio-1) > 0.2 {
+        t.Errorf("virial ratio %v, want about 1", ratio)
+    }
+}
+
+func TestInitializeDiskProfile(t *testing.T) {
+    // without a black hole, and truncated far out, the disk is a plain exponential
+    // disk, whose stars lie at 2 scale lengths on average
+    p := DiskParameters{diskMass: solarMass * 4000, scaleLength: 1, truncation: 100, dispersion: 50}
+    g := InitializeDisk(4000, OrderedPair{}, p, rand.New(rand.NewSource(2)))
+    if len(g) != 4000 {
+        t.Fatalf("got %d bodies, want 4000 with no black hole", len(g))
+    }
+    var meanRadius, spread float64
+    for _, s := range g {
+        r := CalcDistance(s.position, OrderedPair{})
+        meanRadius += r
+        // the radial velocity is all random, as the circular motion is tangential
+        if r > 0 {
+            radial := (s.position.x*s.velocity.x + s.position.y*s.velocity.y) / r
+            spread += radial * radial
+        }
+    }
+    meanRadius /= float64(len(g))
+    if math.Abs(meanRadius-2) > 0.1 {
+        t.Errorf("mean radius %v scale lengths, want about 2", meanRadius)
+    }
+    if sigma := math.Sqrt(spread / float64(len(g))); math.Abs(sigma-p.dispersion) > 0.05*p.dispersion {
+        t.Errorf("radial velocity dispersion %v, want about %v", sigma, p.dispersion)
+    }
+}
+
+func TestInitializeDiskHalo(t *testing.T) {
+    const kpc = 1e3 * parsec
+    p := DiskParameters{
+        blackHole:   blackHoleMass,
+        diskMass:    1e9 * solarMass,
+        scaleLength: 3 * kpc,
+        truncation:  15 * kpc,
+        haloMass:    1e11 * solarMass,
+        haloScale:   10 * kpc,
+    }
+    center := OrderedPair{5e22, 5e22}
+    halo := Halo{center: center, mass: p.haloMass, scale: p.haloScale}
+    g := InitializeDisk(150, center, p, rand.New(rand.NewSource(4)))
+
+    // v^2 = G (M_bh + M_disk(<r) + M_halo(<r)) / r
+    radius := func(s *Star) float64 { return CalcDistance(s.position, center) }
+    for _, s := range g[:len(g)-1] {
+        r := radius(s)
+        enclosed := p.blackHole + halo.EnclosedMass(r)
+        for _, other := range g[:len(g)-1] {
+            if radius(other) < r {
+                enclosed += other.mass
+            }
+        }
+        v2 := s.velocity.x*s.velocity.x + s.velocity.y*s.velocity.y
+        if math.Abs(v2*r/G-enclosed) > 1e-9*enclosed {
+            t.Fatalf("star at %v moves at %v, not on the circular orbit of %v kg", r, math.Sqrt(v2), enclosed)
+        }
+    }
+
+    // with the halo's pull, the disk keeps its radial profile for three orbits at its median
+    // radius; without it, the stars are far too fast to stay
+    medianRadius := func(u *Universe) float64 {
+        radii := make([]float64, len(u.stars))
+        for i, s := range u.stars {
+            radii[i] = radius(s)
+        }
+        slices.Sort(radii)
+        return radii[len(radii)/2]
+    }
+    initial := &Universe{stars: g, width: 1e23, softening: Softening{kernel: SofteningPlummer, length: 0.1 * kpc}}
+    before := medianRadius(initial)
+    period := 2 * math.Pi * before / math.Sqrt(G*halo.EnclosedMass(before)/before)
+    const steps = 200
+    for _, solver := range []ForceSolver{HaloSolver{solver: DirectSolver{}, halos: []Halo{halo}}, DirectSolver{}} {
+        var last *Universe
+        SimulateStream(initial, steps, 3*period/steps, solver, KDKIntegrator{}, steps, func(u *Universe) { last = u })
+        after := medianRadius(last)
+        if _, withHalo := solver.(HaloSolver); withHalo && math.Abs(after/before-1) > 0.1 {
+            t.Errorf("median radius went from %v to %v with the halo, want it kept", before, after)
+        } else if !withHalo && after < 2*before {
+            t.Errorf("median radius went from %v to %v without the halo, want the disk to fly apart", before, after)
+        }
+    }
+}
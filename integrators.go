@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// Integrator advances the stars of a universe through one time step.
+// UpdateUniverse hands it a fresh copy of the current universe to update in place.
+type Integrator interface {
+	// Step moves u forward by time, using solver for every force evaluation it needs.
+	// On return each star holds its new position and velocity and its latest acceleration.
+	Step(u *Universe, time float64, solver ForceSolver)
+}
+
+// VerletIntegrator is the scheme BarnesHut has always used: accelerations are computed at the
+// start of the step, velocities use the average of the old and new acceleration, and positions
+// use the old velocity and acceleration. One force evaluation per step.
+type VerletIntegrator struct{}
+
+// KDKIntegrator is kick-drift-kick leapfrog: second order, symplectic, one force evaluation per step.
+type KDKIntegrator struct{}
+
+// DKDIntegrator is drift-kick-drift leapfrog: second order, symplectic, one force evaluation per step.
+type DKDIntegrator struct{}
+
+// YoshidaIntegrator is Yoshida's fourth order symplectic scheme, built from three leapfrog
+// steps of weighted lengths. Three force evaluations per step.
+type YoshidaIntegrator struct{}
+
+// RK4Integrator is the classic fourth order Runge-Kutta method. It is not symplectic, so energy
+// drifts slowly over long runs. Four force evaluations per step.
+type RK4Integrator struct{}
+
+// EulerIntegrator is forward Euler: first order and not symplectic. One force evaluation per step.
+type EulerIntegrator struct{}
+
+// Step applies the original velocity Verlet style update.
+func (VerletIntegrator) Step(u *Universe, time float64, solver ForceSolver) {
+	buf := GetScratch(len(u.stars))
+	defer PutScratch(buf)
+	acc := *buf
+	solver.ComputeAccelerations(u, acc)
+
+	for i, s := range u.stars {
+		oldAcceleration, oldVelocity := s.acceleration, s.velocity
+		s.acceleration = acc[i]
+		s.velocity = UpdateVelocity(s, oldAcceleration, time)
+		s.position = UpdatePosition(s, oldAcceleration, oldVelocity, time)
+	}
+}
+
+// Step kicks by half a step with the stored acceleration, drifts a whole step, recomputes
+// accelerations and kicks by the other half.
+func (KDKIntegrator) Step(u *Universe, time float64, solver ForceSolver) {
+	buf := GetScratch(len(u.stars))
+	defer PutScratch(buf)
+	acc := *buf
+	PrimeAccelerations(u, solver, acc)
+
+	Kick(u.stars, time/2)
+	Drift(u.stars, time)
+	solver.ComputeAccelerations(u, acc)
+	SetAccelerations(u.stars, acc)
+	Kick(u.stars, time/2)
+}
+
+// Step drifts by half a step, computes accelerations there, kicks a whole step and drifts the other half.
+func (DKDIntegrator) Step(u *Universe, time float64, solver ForceSolver) {
+	buf := GetScratch(len(u.stars))
+	defer PutScratch(buf)
+	acc := *buf
+
+	Drift(u.stars, time/2)
+	solver.ComputeAccelerations(u, acc)
+	SetAccelerations(u.stars, acc)
+	Kick(u.stars, time)
+	Drift(u.stars, time/2)
+}
+
+// Step applies the drift and kick coefficients of Yoshida (1990).
+func (YoshidaIntegrator) Step(u *Universe, time float64, solver ForceSolver) {
+	cbrt2 := math.Cbrt(2)
+	w1 := 1 / (2 - cbrt2)
+	w0 := -cbrt2 / (2 - cbrt2)
+	drifts := [4]float64{w1 / 2, (w0 + w1) / 2, (w0 + w1) / 2, w1 / 2}
+	kicks := [3]float64{w1, w0, w1}
+
+	buf := GetScratch(len(u.stars))
+	defer PutScratch(buf)
+	acc := *buf
+	for i, k := range kicks {
+		Drift(u.stars, drifts[i]*time)
+		solver.ComputeAccelerations(u, acc)
+		SetAccelerations(u.stars, acc)
+		Kick(u.stars, k*time)
+	}
+	Drift(u.stars, drifts[3]*time)
+}
+
+// Step evaluates the four Runge-Kutta stages on a scratch copy of u and combines them.
+// Each star keeps the acceleration from the start of the step.
+func (RK4Integrator) Step(u *Universe, time float64, solver ForceSolver) {
+	n := len(u.stars)
+	buf := GetScratch(10 * n)
+	defer PutScratch(buf)
+	x0, v0 := (*buf)[:n], (*buf)[n:2*n]
+	for i, s := range u.stars {
+		x0[i], v0[i] = s.position, s.velocity
+	}
+
+	// kx[k] and kv[k] are the position and velocity derivatives at stage k
+	var kx, kv [4][]OrderedPair
+	for k := range kx {
+		kx[k] = (*buf)[(2+2*k)*n : (3+2*k)*n]
+		kv[k] = (*buf)[(3+2*k)*n : (4+2*k)*n]
+	}
+
+	copy(kx[0], v0)
+	solver.ComputeAccelerations(u, kv[0])
+
+	scratch := scratchUniverses.Get().(*Universe)
+	defer scratchUniverses.Put(scratch)
+	CopyUniverseInto(scratch, u)
+	fractions := [3]float64{0.5, 0.5, 1}
+	for k := 1; k < 4; k++ {
+		h := fractions[k-1] * time
+		for i, s := range scratch.stars {
+			s.position = OrderedPair{x0[i].x + kx[k-1][i].x*h, x0[i].y + kx[k-1][i].y*h}
+			kx[k][i] = OrderedPair{v0[i].x + kv[k-1][i].x*h, v0[i].y + kv[k-1][i].y*h}
+		}
+		solver.ComputeAccelerations(scratch, kv[k])
+	}
+
+	for i, s := range u.stars {
+		s.position.x = x0[i].x + time/6*(kx[0][i].x+2*kx[1][i].x+2*kx[2][i].x+kx[3][i].x)
+		s.position.y = x0[i].y + time/6*(kx[0][i].y+2*kx[1][i].y+2*kx[2][i].y+kx[3][i].y)
+		s.velocity.x = v0[i].x + time/6*(kv[0][i].x+2*kv[1][i].x+2*kv[2][i].x+kv[3][i].x)
+		s.velocity.y = v0[i].y + time/6*(kv[0][i].y+2*kv[1][i].y+2*kv[2][i].y+kv[3][i].y)
+		s.acceleration = kv[0][i]
+	}
+}
+
+// Step moves every star with its current velocity and kicks it with its current acceleration.
+func (EulerIntegrator) Step(u *Universe, time float64, solver ForceSolver) {
+	buf := GetScratch(len(u.stars))
+	defer PutScratch(buf)
+	acc := *buf
+	solver.ComputeAccelerations(u, acc)
+	SetAccelerations(u.stars, acc)
+
+	Drift(u.stars, time)
+	Kick(u.stars, time)
+}
+
+// PrimeAccelerations fills in the accelerations of a universe that hasn't taken a step yet, since
+// stars start out with zero acceleration. Schemes that start by kicking with the stored acceleration need it.
+// acc is scratch space with one entry per star.
+func PrimeAccelerations(u *Universe, solver ForceSolver, acc []OrderedPair) {
+	if u.step != 0 {
+		return
+	}
+	solver.ComputeAccelerations(u, acc)
+	SetAccelerations(u.stars, acc)
+}
+
+// Kick changes the velocity of every star by its acceleration times time.
+func Kick(stars []*Star, time float64) {
+	for _, s := range stars {
+		s.velocity.x += s.acceleration.x * time
+		s.velocity.y += s.acceleration.y * time
+	}
+}
+
+// Drift moves every star by its velocity times time.
+func Drift(stars []*Star, time float64) {
+	for _, s := range stars {
+		s.position.x += s.velocity.x * time
+		s.position.y += s.velocity.y * time
+	}
+}
+
+// SetAccelerations stores acc[i] as the acceleration of stars[i].
+func SetAccelerations(stars []*Star, acc []OrderedPair) {
+	for i, s := range stars {
+		s.acceleration = acc[i]
+	}
+}
+
+// Integrators borrow their working memory from these pools instead of allocating it every step,
+// so that stepping with UpdateUniverseInto allocates nothing once the pools are warm.
+var (
+	scratchPairs     = sync.Pool{New: func() any { return new([]OrderedPair) }}
+	scratchUniverses = sync.Pool{New: func() any { return new(Universe) }}
+)
+
+// GetScratch returns a pooled slice of n OrderedPairs (with arbitrary contents). Hand it back with PutScratch.
+func GetScratch(n int) *[]OrderedPair {
+	buf := scratchPairs.Get().(*[]OrderedPair)
+	if cap(*buf) < n {
+		*buf = make([]OrderedPair, n)
+	}
+	*buf = (*buf)[:n]
+	return buf
+}
+
+// PutScratch returns a slice from GetScratch to the pool.
+func PutScratch(buf *[]OrderedPair) {
+	scratchPairs.Put(buf)
+}
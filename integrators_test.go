@@ -0,0 +1,59 @@
+package main
+
+import (
+    "math"
+    "testing"
+)
+
+// circularOrbit returns a light star on a circular orbit around a heavy one, and the orbital period.
+func circularOrbit() (*Universe, float64) {
+    M, r := 1e30, 1e9
+    v := math.Sqrt(G * M / r)
+    u := &Universe{width: 4e9, stars: []*Star{
+        {position: OrderedPair{2e9, 2e9}, mass: M},
+        {position: OrderedPair{2e9 + r, 2e9}, velocity: OrderedPair{0, v}, mass: 1},
+    }}
+    return u, 2 * math.Pi * r / v
+}
+
+func TestIntegrators(t *testing.T) {
+    // distance from the starting point after one orbit of 200 steps; higher order schemes must do better
+    integrators := []Integrator{EulerIntegrator{}, KDKIntegrator{}, DKDIntegrator{}, YoshidaIntegrator{}, RK4Integrator{}}
+    limits := []float64{2, 5e-3, 5e-3, 2e-5, 1e-6}
+
+    for k, integrator := range integrators {
+        u, period := circularOrbit()
+        timePoints := Simulate(u, 200, period/200, DirectSolver{}, integrator)
+        last := timePoints[len(timePoints)-1]
+
+        start := OrderedPair{3e9, 2e9}
+        if err := CalcDistance(last.stars[1].position, start) / 1e9; err > limits[k] {
+            t.Errorf("%T: position error %v radii after one orbit, want <= %v", integrator, err, limits[k])
+        }
+    }
+}
+
+func TestSimulateStream(t *testing.T) {
+    u, period := circularOrbit()
+    dt := period / 100
+    full := Simulate(u, 100, dt, DirectSolver{}, KDKIntegrator{})
+    sampled := SimulateSampled(u, 100, dt, DirectSolver{}, KDKIntegrator{}, 25)
+
+    if len(sampled) != 5 {
+        t.Fatalf("got %d sampled universes, want 5", len(sampled))
+    }
+    for i, s := range sampled {
+        want := full[i*25]
+        if s.step != want.step || s.stars[1].position != want.stars[1].position {
+            t.Errorf("sample %d: step %d at %v, want step %d at %v", i, s.step, s.stars[1].position, want.step, want.stars[1].position)
+        }
+    }
+
+    count := 0
+    for range SimulateChannel(u, 100, dt, DirectSolver{}, KDKIntegrator{}, 10) {
+        count++
+    }
+    if count != 11 {
+        t.Errorf("channel delivered %d universes, want 11", count)
+    }
+}
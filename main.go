@@ -1,121 +1,113 @@
 package main
 
 import (
-	"bufio"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"os"
-	"strconv"
-	"strings"
+	"path/filepath"
 	"gifhelper"
 	"math"
+	"math/rand"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run main.go <command>")
-		fmt.Println("Commands: jupiter | galaxy | collision")
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Println("Usage: go run . <command> [flags]")
+		fmt.Println("Commands: jupiter | galaxy | collision | collision3d | run <scenario.json>")
+		fmt.Println("Run go run . <command> -help for the flags of jupiter, galaxy, collision and collision3d.")
 		return
 	}
+
+	var err error
 	switch os.Args[1] {
-	case "jupiter":
-		GenerateJupiterSystem("./jupiterMoons.txt")
-	case "galaxy":
-		GenerateGalaxy()
-	case "collision":
-		GenerateCollision()
+	case "jupiter", "galaxy", "collision":
+		// the built-in runs are scenario files like any other, with flags to change them
+		err = RunCommand(os.Args[1], os.Args[2:], os.Stderr)
+	case "collision3d":
+		err = RunCollision3(os.Args[2:], os.Stderr)
+	case "run":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: go run . run <scenario.json>")
+			return
+		}
+		err = RunScenarioFile(os.Args[2])
 	default:
 		fmt.Println("Unknown command:", os.Args[1])
 	}
-}
-
-/* ------------------------- Jupiter ------------------------- */
-
-func GenerateJupiterSystem(path string) {
-	initialUniverse, err := ReadJupiterData(path)
-	if err != nil {
-		panic(err)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, ErrUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
-
-	numGens := 40000
-	dt := 7.0     // seconds
-	theta := 0.5
-
-	timePoints := BarnesHut(initialUniverse, numGens, dt, theta)
-
-	fmt.Println("Simulation run. Now drawing images.")
-	canvasWidth := 600
-	frequency := 500         
-	scalingFactor := 5.0
-
-	images := AnimateSystem(timePoints, canvasWidth, frequency, scalingFactor)
-
-	fmt.Println("Images drawn. Now generating GIF.")
-	gifhelper.ImagesToGIF(images, "jupiter")
-	fmt.Println("GIF drawn.")
 }
 
-/* ---------------------------- Single galaxy ---------------------------- */
-
-func GenerateGalaxy() {
-	// One disk galaxy, centered around (5e22, 5e22) with ~500 stars
-	g0 := InitializeGalaxy(500, 4e21, 5e22, 5e22)
-
-	// Wider camera than initial disk so it stays in frame
-	width := 1.0e23
-	galaxies := []Galaxy{g0}
-	initialUniverse := InitializeUniverse(galaxies, width)
-
-	// Parameters tuned for stable, visible rotation and a ~100-frame GIF
-	numGens := 40000
-	dt := 2e16
-	theta := 0.5
-
-	timePoints := BarnesHut(initialUniverse, numGens, dt, theta)
-
-	fmt.Println("Simulation run. Now drawing images.")
-	canvasWidth := 800
-	frequency := 1000
-	scalingFactor := 2e11  // galaxies are sparse—inflate star dots
-
-	images := AnimateSystem(timePoints, canvasWidth, frequency, scalingFactor)
-
-	fmt.Println("Images drawn. Now generating GIF.")
-	gifhelper.ImagesToGIF(images, "galaxy")
-	fmt.Println("GIF drawn.")
-
+// BuiltInScenario returns the path of the scenario file run by a built-in command.
+func BuiltInScenario(command string) string {
+	return filepath.Join("scenarios", command+".json")
 }
 
-/* ---------------------------- Galaxy collision ---------------------------- */
-func GenerateCollision() {
-    // Two disks offset so they will graze and merge
-    g0 := InitializeGalaxy(300, 4e21, 7e22, 2e22)
-    g1 := InitializeGalaxy(300, 4e21, 3e22, 7e22)
-
-    // Push galaxies toward each other along their connecting line
-    PushGalaxies(g0, g1, 5e3)
+/* ---------------------------- Galaxy collision in 3D ---------------------------- */
+// GenerateCollision3 simulates and draws a collision of two 3D galaxies laid out with the given seed.
+func GenerateCollision3(seed int64) {
+    // The same two disks as the collision scenario, tilted against each other and the line of sight
+    fmt.Println("Seed:", seed)
+    rng := rand.New(rand.NewSource(seed))
+    g0 := InitializeGalaxy3(300, 4e21, Vector3{7e22, 2e22, 5e22}, math.Pi/6, 0, rng)
+    g1 := InitializeGalaxy3(300, 4e21, Vector3{3e22, 7e22, 5e22}, -math.Pi/4, math.Pi/3, rng)
+    PushGalaxies3(g0, g1, 5e3)
 
     width := 1e23
-    initialUniverse := InitializeUniverse([]Galaxy{g0, g1}, width)
+    initialUniverse := InitializeUniverse3([]Galaxy3{g0, g1}, width)
+    initialUniverse.softening = Softening{kernel: SofteningPlummer, length: 1e20}
 
     numGens := 100000
-    dt := 1e15     
-    theta := 0.5
+    dt := 1e15
+    params := ForceParameters{theta: 0.5, softening: initialUniverse.softening}
+    leafCapacity := 1
+    frequency := 1000
 
-    fmt.Println("Starting collision simulation with", len(initialUniverse.stars), "stars.")
-    timePoints := BarnesHut(initialUniverse, numGens, dt, theta)
+    fmt.Println("Starting 3D collision simulation with", len(initialUniverse.stars), "stars.")
+    // keep only the generations we draw
+    frames := []*Universe3{initialUniverse}
+    current := initialUniverse
+    for i := 1; i <= numGens; i++ {
+        current = UpdateUniverse3(current, dt, params, leafCapacity)
+        if i%frequency == 0 {
+            frames = append(frames, current)
+        }
+    }
 
-    // Visualization parameters
+    // seen from above and a little to the side, so the tilt of the disks shows
     canvasWidth := 1400
-    frequency := 1000
     scalingFactor := 1.5e11
-    images := AnimateSystem(timePoints, canvasWidth, frequency, scalingFactor)
+    view := Projection{azimuth: math.Pi / 8, elevation: math.Pi / 5}
+    images := AnimateSystem3(frames, canvasWidth, 1, scalingFactor, view)
 
-    gifhelper.ImagesToGIF(images, "collision")
+    gifhelper.ImagesToGIF(images, "collision3d")
     fmt.Println("GIF drawn.")
 }
 
+// PushGalaxies3 is PushGalaxies for 3D galaxies.
+func PushGalaxies3(g0, g1 Galaxy3, speed float64) {
+    c0 := CenterOfMass3(g0)
+    c1 := CenterOfMass3(g1)
+    dist := CalcDistance3(c0, c1)
+    if dist == 0 {
+        return
+    }
 
+    dir := Vector3{(c1.x - c0.x) / dist, (c1.y - c0.y) / dist, (c1.z - c0.z) / dist}
+    for _, s := range g0 {
+        s.velocity = Vector3{dir.x * speed, dir.y * speed, dir.z * speed}
+    }
+    for _, s := range g1 {
+        s.velocity = Vector3{-dir.x * speed, -dir.y * speed, -dir.z * speed}
+    }
+}
 
 // PushGalaxies gives two galaxies equal and opposite velocity pushes
 // so they drift toward each other for a collision simulation.
@@ -146,125 +138,26 @@ func PushGalaxies(g0, g1 Galaxy, speed float64) {
     }
 }
 
+// PushVelocity returns the velocity PushGalaxies gives the stars of g0 to send it toward g1 at the
+// given speed; those of g1 get the opposite one. It is zero if their centers of mass coincide.
+func PushVelocity(g0, g1 Galaxy, speed float64) OrderedPair {
+    c0 := CenterOfMass(g0)
+    c1 := CenterOfMass(g1)
+    dx := c1.x - c0.x
+    dy := c1.y - c0.y
+    dist := math.Sqrt(dx*dx + dy*dy)
+    if dist == 0 {
+        return OrderedPair{}
+    }
+    return OrderedPair{dx / dist * speed, dy / dist * speed}
+}
 
 
 
-/* ------------------------------ File reader (Generated by Chatgpt 5.0) ------------------------------ */
-
-func ReadJupiterData(path string) (*Universe, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	sc := bufio.NewScanner(f)
-	read := func() (string, error) {
-		for sc.Scan() {
-			s := strings.TrimSpace(sc.Text())
-			if s == "" {
-				continue
-			}
-			return s, nil
-		}
-		if err := sc.Err(); err != nil {
-			return "", err
-		}
-		return "", io.EOF
-	}
-
-	// width
-	wstr, err := read()
-	if err != nil {
-		return nil, fmt.Errorf("width: %w", err)
-	}
-	width, err := strconv.ParseFloat(wstr, 64)
-	if err != nil {
-		return nil, fmt.Errorf("width parse: %w", err)
-	}
-
-	// G (ignored; we use const G in code)
-	if _, err := read(); err != nil {
-		return nil, fmt.Errorf("G line: %w", err)
-	}
-
-	var stars []*Star
-	for {
-		// >Name
-		h, err := read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		if !strings.HasPrefix(h, ">") {
-			return nil, fmt.Errorf("expected '>' header, got %q", h)
-		}
-
-		// RGB
-		rgbLine, err := read()
-		if err != nil {
-			return nil, err
-		}
-		var R, Gc, B int
-		if _, err := fmt.Sscanf(rgbLine, "%d,%d,%d", &R, &Gc, &B); err != nil {
-			return nil, fmt.Errorf("rgb parse %q: %w", rgbLine, err)
-		}
-
-		// mass
-		massLine, err := read()
-		if err != nil {
-			return nil, err
-		}
-		mass, err := strconv.ParseFloat(massLine, 64)
-		if err != nil {
-			return nil, fmt.Errorf("mass parse %q: %w", massLine, err)
-		}
-
-		// radius
-		radLine, err := read()
-		if err != nil {
-			return nil, err
-		}
-		radius, err := strconv.ParseFloat(radLine, 64)
-		if err != nil {
-			return nil, fmt.Errorf("radius parse %q: %w", radLine, err)
-		}
-
-		// position
-		posLine, err := read()
-		if err != nil {
-			return nil, err
-		}
-		var px, py float64
-		if _, err := fmt.Sscanf(posLine, "%f,%f", &px, &py); err != nil {
-			return nil, fmt.Errorf("pos parse %q: %w", posLine, err)
-		}
-
-		// velocity
-		velLine, err := read()
-		if err != nil {
-			return nil, err
-		}
-		var vx, vy float64
-		if _, err := fmt.Sscanf(velLine, "%f,%f", &vx, &vy); err != nil {
-			return nil, fmt.Errorf("vel parse %q: %w", velLine, err)
-		}
 
-		stars = append(stars, &Star{
-			position:     OrderedPair{px, py},
-			velocity:     OrderedPair{vx, vy},
-			acceleration: OrderedPair{},
-			mass:         mass,
-			radius:       radius,
-			// file is R,G,B; struct is red, blue, green
-			red:   uint8(R),
-			blue:  uint8(B),
-			green: uint8(Gc),
-		})
-	}
+/* ------------------------------ File reader ------------------------------ */
 
-	return &Universe{stars: stars, width: width}, nil
+// ReadJupiterData reads the Jupiter system from a body file (see bodyfile.go for the format).
+func ReadJupiterData(path string) (*Universe, error) {
+	return LoadBodies(path)
 }
-
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// mortonBits is the number of bits per axis in a Morton key, which is also the deepest level
+// of a FlatTree. Stars sharing a cell at that level end up in one leaf.
+const mortonBits = 32
+
+// FlatTree is a QuadTree stored as one slice of nodes in depth-first order, built by sorting the
+// stars along a Z-order (Morton) curve. Nodes refer to each other by index, so building it allocates
+// nothing once its slices have grown, and FlatNetForce walks it with a loop instead of recursion.
+// A FlatTree is meant to be kept and rebuilt every step.
+type FlatTree struct {
+	nodes           []FlatNode
+	stars           []*Star // the stars in Morton order; every node covers a contiguous run of them
+	dummies         []Star  // dummy stars of the nodes, indexed like nodes
+	entries         []mortonEntry
+	collapsedLeaves int
+
+	// error moments are only needed by CriterionSalmonWarren, and unlike the rest of a node's
+	// summary they can't be combined from its children, so they are skipped unless asked for
+	needMoments bool
+}
+
+// FlatNode is a node of a FlatTree. Its embedded Node holds the same summary a QuadTree node has
+// (dummy star, sector, quadrupole and error moments), except that children is always nil and
+// stars is only set on leaves holding more than one star. After a Refit the sectors are left as they
+// were built, so stars may lie a little outside them.
+type FlatNode struct {
+	Node
+	leaf         bool
+	first, count int // the node's stars are tree.stars[first : first+count]
+	next         int // index of the first node after this node's subtree
+}
+
+// mortonEntry pairs a star with its Morton key while sorting.
+type mortonEntry struct {
+	key   uint64
+	index int
+	star  *Star
+}
+
+// Build takes as input the stars of a universe and a leaf capacity and rebuilds the tree for them,
+// reusing the memory of the previous build.
+func (tree *FlatTree) Build(stars []*Star, leafCapacity int) {
+	if len(stars) == 0 {
+		panic("No stars in universe for FlatTree construction")
+	}
+	root := BoundingQuadrant(stars)
+
+	// key every star by its cell at the finest level, then sort along the Z curve
+	tree.entries = tree.entries[:0]
+	for i, s := range stars {
+		tree.entries = append(tree.entries, mortonEntry{MortonKey(s.position, root), i, s})
+	}
+	slices.SortFunc(tree.entries, func(a, b mortonEntry) int {
+		if a.key != b.key {
+			if a.key < b.key {
+				return -1
+			}
+			return 1
+		}
+		return a.index - b.index
+	})
+	tree.stars = tree.stars[:0]
+	for _, e := range tree.entries {
+		tree.stars = append(tree.stars, e.star)
+	}
+
+	tree.nodes = tree.nodes[:0]
+	tree.collapsedLeaves = 0
+	tree.buildRange(0, len(stars), 0, 0, 0, root, max(leafCapacity, 1))
+
+	// fill in the summaries now that the node slice won't move any more
+	if cap(tree.dummies) < len(tree.nodes) {
+		tree.dummies = make([]Star, len(tree.nodes))
+	}
+	tree.dummies = tree.dummies[:len(tree.nodes)]
+	tree.summarize()
+}
+
+// Refit takes as input the stars of a universe, in the same order as when the tree was built
+// (normally their copies one or more steps later), and updates the tree to their new positions
+// without changing its shape. It returns false, leaving the tree unusable until the next Build,
+// if the stars no longer match or any star has strayed further than tolerance times its leaf's
+// width outside that leaf's sector.
+func (tree *FlatTree) Refit(stars []*Star, tolerance float64) bool {
+	if len(stars) != len(tree.entries) {
+		return false
+	}
+	for k := range tree.entries {
+		tree.stars[k] = stars[tree.entries[k].index]
+	}
+
+	for i := range tree.nodes {
+		node := &tree.nodes[i]
+		if !node.leaf {
+			continue
+		}
+		slack := tolerance * node.sector.width
+		for _, s := range tree.stars[node.first : node.first+node.count] {
+			outside := math.Max(math.Max(node.sector.x-s.position.x, s.position.x-node.sector.x-node.sector.width),
+				math.Max(node.sector.y-s.position.y, s.position.y-node.sector.y-node.sector.width))
+			if outside > slack {
+				return false
+			}
+		}
+	}
+
+	tree.summarize()
+	return true
+}
+
+// summarize fills in the dummy star, quadrupole and farthest-star distance of every node from the
+// bottom up. Children always come after their parent in tree.nodes, so going backwards through the
+// slice reaches every child before its parent. Error moments are worked out from the stars directly.
+func (tree *FlatTree) summarize() {
+	for i := len(tree.nodes) - 1; i >= 0; i-- {
+		node := &tree.nodes[i]
+		run := tree.stars[node.first : node.first+node.count]
+		if node.count == 1 {
+			node.star = run[0]
+			continue
+		}
+
+		dummy := &tree.dummies[i]
+		node.star = dummy
+		if node.leaf {
+			*dummy = Star{position: CenterOfMass(run), mass: SumStarMasses(run)}
+			node.quadrupole = QuadrupoleOf(run, dummy.position)
+			node.bmax, _ = ErrorMoments(run, dummy.position)
+		} else {
+			// combine the children: total mass, then center of mass, then shifted moments
+			*dummy = Star{}
+			for c := i + 1; c < node.next; c = tree.nodes[c].next {
+				child := tree.nodes[c].star
+				dummy.mass += child.mass
+				dummy.position.x += child.mass * child.position.x
+				dummy.position.y += child.mass * child.position.y
+			}
+			if dummy.mass != 0 {
+				dummy.position.x /= dummy.mass
+				dummy.position.y /= dummy.mass
+			}
+			node.quadrupole = Quadrupole{}
+			node.bmax = 0
+			for c := i + 1; c < node.next; c = tree.nodes[c].next {
+				child := &tree.nodes[c]
+				dx := child.star.position.x - dummy.position.x
+				dy := child.star.position.y - dummy.position.y
+				node.quadrupole.xx += child.quadrupole.xx
+				node.quadrupole.xy += child.quadrupole.xy
+				node.quadrupole.yy += child.quadrupole.yy
+				node.quadrupole = AddQuadrupole(node.quadrupole, child.star.mass, dx, dy)
+				// no star of the child is farther than this, though the true maximum may be smaller
+				node.bmax = math.Max(node.bmax, child.bmax+math.Hypot(dx, dy))
+			}
+		}
+		if tree.needMoments {
+			_, node.moments = ErrorMoments(run, dummy.position)
+		}
+	}
+}
+
+// buildRange appends the node for the sorted stars [lo, hi), which all lie in cell (cx, cy) of
+// the given level, followed by its subtree in depth-first order (NW, NE, SW, SE like BuildNode).
+func (tree *FlatTree) buildRange(lo, hi, level int, cx, cy uint64, root Quadrant, leafCapacity int) {
+	index := len(tree.nodes)
+	width := root.width / float64(uint64(1)<<level)
+	tree.nodes = append(tree.nodes, FlatNode{first: lo, count: hi - lo})
+	tree.nodes[index].sector = Quadrant{root.x + float64(cx)*width, root.y + float64(cy)*width, width}
+
+	if hi-lo <= leafCapacity || level == mortonBits {
+		node := &tree.nodes[index]
+		node.leaf = true
+		if hi-lo > 1 {
+			node.stars = tree.stars[lo:hi:hi]
+		}
+		if hi-lo > leafCapacity {
+			tree.collapsedLeaves++
+		}
+		node.next = len(tree.nodes)
+		return
+	}
+
+	// the two key bits below this level say which child quadrant a star is in
+	shift := uint(2 * (mortonBits - 1 - level))
+	start := lo
+	for digit := uint64(0); digit < 4; digit++ {
+		// binary search for the first star past this child (keys are sorted)
+		end, top := start, hi
+		for end < top {
+			mid := int(uint(end+top) >> 1)
+			if (tree.entries[mid].key>>shift)&3 > digit {
+				top = mid
+			} else {
+				end = mid + 1
+			}
+		}
+		if end > start {
+			xBit := digit & 1
+			yBit := 1 - digit>>1
+			tree.buildRange(start, end, level+1, 2*cx+xBit, 2*cy+yBit, root, leafCapacity)
+		}
+		start = end
+	}
+	tree.nodes[index].next = len(tree.nodes)
+}
+
+// MortonKey takes as input a position and the root quadrant and returns the Z-order key of the
+// finest-level cell holding the position. Each level contributes two bits, (north?0:1, east?1:0),
+// so sorting by key visits child quadrants in the same NW, NE, SW, SE order as ChildIndex.
+func MortonKey(p OrderedPair, root Quadrant) uint64 {
+	cells := float64(uint64(1) << mortonBits)
+	cx := uint64(math.Min(math.Max((p.x-root.x)/root.width*cells, 0), cells-1))
+	cy := uint64(math.Min(math.Max((p.y-root.y)/root.width*cells, 0), cells-1))
+
+	var key uint64
+	for bit := mortonBits - 1; bit >= 0; bit-- {
+		xBit := (cx >> uint(bit)) & 1
+		yBit := (cy >> uint(bit)) & 1
+		key = key<<2 | (1-yBit)<<1 | xBit
+	}
+	return key
+}
+
+// FlatAcceleration is UpdateAcceleration for a FlatTree.
+func FlatAcceleration(tree *FlatTree, s *Star, params ForceParameters) OrderedPair {
+	force := FlatNetForce(tree, s, params)
+	return OrderedPair{force.x / s.mass, force.y / s.mass}
+}
+
+// FlatNetForce is CalculateNetForce for a FlatTree. Instead of recursing, it steps through the
+// nodes in order: a node that is approximated or summed directly is skipped past using next,
+// and a node that must be opened is followed by its first child.
+func FlatNetForce(tree *FlatTree, currStar *Star, params ForceParameters) OrderedPair {
+	var NetForce OrderedPair
+	add := func(f OrderedPair) {
+		NetForce.x += f.x
+		NetForce.y += f.y
+	}
+
+	for i := 0; i < len(tree.nodes); {
+		node := &tree.nodes[i]
+
+		switch {
+		case node.count == 1:
+			// a single star
+			if node.star != currStar {
+				add(CalcSoftenedForce(currStar, node.star, G, params.softening))
+			}
+		case !ContainsStar(node.stars, currStar) && AcceptNode(&node.Node, currStar, params):
+			// use the cluster approximation
+			add(CalcSoftenedForce(currStar, node.star, G, params.softening))
+			if params.quadrupole {
+				add(QuadrupoleForce(currStar, &node.Node))
+			}
+		case node.leaf:
+			// sum the stars sharing this leaf directly
+			for _, star := range node.stars {
+				if star != currStar {
+					add(CalcSoftenedForce(currStar, star, G, params.softening))
+				}
+			}
+		default:
+			// look inside this cluster
+			i++
+			continue
+		}
+		i = node.next
+	}
+	return NetForce
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "math"
+    "testing"
+)
+
+func TestFlatTree(t *testing.T) {
+    u := randomUniverse(2000, 1e18, 7)
+    params := ForceParameters{theta: 0.6, quadrupole: true}
+
+    tree := GenerateQuadTree(u, 4, 0)
+    var flat FlatTree
+    flat.Build(u.stars, 4)
+
+    // the two builders split space the same way, so forces agree to rounding
+    for _, s := range u.stars {
+        want := CalculateNetForce(tree.root, s, params)
+        got := FlatNetForce(&flat, s, params)
+        if math.Hypot(got.x-want.x, got.y-want.y) > 1e-9*math.Hypot(want.x, want.y) {
+            t.Fatalf("star at %v: flat tree gives %v, QuadTree gives %v", s.position, got, want)
+        }
+    }
+
+    mean, _ := ForceError(u, &BarnesHutSolver{theta: 0.6, leafCapacity: 4, quadrupole: true, builder: TreeBuilderMorton}, DirectSolver{})
+    if mean > 1e-2 {
+        t.Errorf("mean relative error %v", mean)
+    }
+
+    // coincident stars collapse into one leaf instead of recursing past the key resolution
+    u.stars = append(u.stars, CopyStar(u.stars[0]), CopyStar(u.stars[0]))
+    flat.Build(u.stars, 1)
+    if flat.collapsedLeaves != 1 {
+        t.Errorf("collapsed leaves = %d, want 1", flat.collapsedLeaves)
+    }
+}
+
+func TestFlatTreeRefit(t *testing.T) {
+    u := randomUniverse(2000, 1e18, 9)
+    params := ForceParameters{theta: 0.6, quadrupole: true}
+    var reused FlatTree
+    reused.Build(u.stars, 4)
+
+    // nudge every star a little; the refitted tree should give nearly the forces of a fresh one
+    moved := CopyUniverse(u)
+    for i, s := range moved.stars {
+        s.position.x += 1e11 * math.Cos(float64(i))
+        s.position.y += 1e11 * math.Sin(float64(i))
+    }
+    if !reused.Refit(moved.stars, 0.1) {
+        t.Fatal("refit refused a small move")
+    }
+    if root := reused.nodes[0].star; math.Abs(root.mass-SumStarMasses(moved.stars)) > 1e-12*root.mass {
+        t.Errorf("refitted root mass %v, want %v", root.mass, SumStarMasses(moved.stars))
+    }
+    var fresh FlatTree
+    fresh.Build(moved.stars, 4)
+    for _, s := range moved.stars {
+        want := FlatNetForce(&fresh, s, params)
+        got := FlatNetForce(&reused, s, params)
+        if math.Hypot(got.x-want.x, got.y-want.y) > 1e-3*math.Hypot(want.x, want.y) {
+            t.Fatalf("star at %v: refitted tree gives %v, fresh tree gives %v", s.position, got, want)
+        }
+    }
+
+    // a star thrown across the universe forces a rebuild, and so does a change in the number of stars
+    moved.stars[0].position.x += 5e17
+    if reused.Refit(moved.stars, 0.1) {
+        t.Error("refit accepted a star far outside its leaf")
+    }
+    if reused.Refit(moved.stars[1:], 0.1) {
+        t.Error("refit accepted a different number of stars")
+    }
+}
+
+func BenchmarkTreeBuilders(b *testing.B) {
+    u := randomUniverse(100000, 1e18, 8)
+    b.Run("recursive", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            GenerateQuadTree(u, 8, 0)
+        }
+    })
+    b.Run("morton", func(b *testing.B) {
+        var flat FlatTree
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            flat.Build(u.stars, 8)
+        }
+    })
+}
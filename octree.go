@@ -0,0 +1,326 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// The 3D path mirrors the 2D one: a Universe3 of Star3s, an Octree in place of the QuadTree,
+// and the same geometric opening test and softening. Quadrupoles, the other opening criteria,
+// boundary policies and the pluggable solvers and integrators are 2D only.
+
+// BarnesHut3 takes as input an initial 3D universe, a number of generations, a time interval,
+// a theta value and a leaf capacity. It returns the universe at every generation, advanced with
+// velocity Verlet and Barnes-Hut forces from an octree.
+func BarnesHut3(initialUniverse *Universe3, numGens int, time, theta float64, leafCapacity int) []*Universe3 {
+	timePoints := make([]*Universe3, numGens+1)
+	timePoints[0] = initialUniverse
+
+	params := ForceParameters{theta: theta, softening: initialUniverse.softening}
+	for i := 1; i <= numGens; i++ {
+		timePoints[i] = UpdateUniverse3(timePoints[i-1], time, params, leafCapacity)
+	}
+	return timePoints
+}
+
+// UpdateUniverse3 takes as input a 3D universe, a time interval, the tree walk parameters and
+// a leaf capacity. It returns the universe one step later. Stars hold their acceleration from the
+// previous step; on the very first step it is worked out from the initial positions.
+func UpdateUniverse3(currentUniverse *Universe3, time float64, params ForceParameters, leafCapacity int) *Universe3 {
+	newUniverse := CopyUniverse3(currentUniverse)
+	newUniverse.step = currentUniverse.step + 1
+	if len(newUniverse.stars) == 0 {
+		return newUniverse
+	}
+	if currentUniverse.step == 0 {
+		SetAccelerations3(newUniverse, params, leafCapacity)
+	}
+
+	oldAccelerations := make([]Vector3, len(newUniverse.stars))
+	for i, s := range newUniverse.stars {
+		oldAccelerations[i] = s.acceleration
+		s.position.x += s.velocity.x*time + 0.5*s.acceleration.x*time*time
+		s.position.y += s.velocity.y*time + 0.5*s.acceleration.y*time*time
+		s.position.z += s.velocity.z*time + 0.5*s.acceleration.z*time*time
+	}
+
+	SetAccelerations3(newUniverse, params, leafCapacity)
+
+	for i, s := range newUniverse.stars {
+		s.velocity.x += 0.5 * (s.acceleration.x + oldAccelerations[i].x) * time
+		s.velocity.y += 0.5 * (s.acceleration.y + oldAccelerations[i].y) * time
+		s.velocity.z += 0.5 * (s.acceleration.z + oldAccelerations[i].z) * time
+	}
+	return newUniverse
+}
+
+// SetAccelerations3 builds an octree of a 3D universe and sets the acceleration of every star
+// from it, spreading the stars over all CPUs.
+func SetAccelerations3(u *Universe3, params ForceParameters, leafCapacity int) {
+	tree := GenerateOctree(u, leafCapacity)
+	ParallelFor(len(u.stars), 0, func(i int) {
+		s := u.stars[i]
+		force := CalculateNetForce3(tree.root, s, params)
+		s.acceleration = Vector3{force.x / s.mass, force.y / s.mass, force.z / s.mass}
+	})
+}
+
+// GenerateOctree takes as input a 3D universe and a leaf capacity, and returns an Octree whose
+// leaves hold up to leafCapacity stars each. The root is the bounding cube of the stars.
+func GenerateOctree(currentUniverse *Universe3, leafCapacity int) Octree {
+	if len(currentUniverse.stars) == 0 {
+		panic("No stars in universe for Octree construction")
+	}
+
+	rootOctant := BoundingOctant(currentUniverse.stars)
+	return Octree{root: BuildNode3(rootOctant, currentUniverse.stars, leafCapacity)}
+}
+
+// BuildNode3 takes as input an octant, a slice of star pointers and a leaf capacity, and returns
+// the octree node of the stars inside the octant. Like BuildNodeWithCapacity, it splits until no
+// leaf holds more than leafCapacity stars, except for stars too close together to separate.
+func BuildNode3(octant Octant, stars []*Star3, leafCapacity int) *Node3 {
+	return buildNode3AtDepth(octant, CountStarsInOctant(octant, stars), 0, leafCapacity)
+}
+
+// buildNode3AtDepth is BuildNode3 for an octant depth levels below the root, whose stars have
+// already been filtered (see buildNodeAtDepth).
+func buildNode3AtDepth(octant Octant, starList []*Star3, depth, leafCapacity int) *Node3 {
+	n := len(starList)
+	if n == 0 {
+		return nil
+	}
+	if n == 1 {
+		return &Node3{star: starList[0], sector: octant}
+	}
+	if n <= leafCapacity || depth >= maxTreeDepth || !CanSplitOctant(octant) {
+		return &Node3{star: ClusterStar3(starList), stars: starList, sector: octant}
+	}
+
+	subOctants := SplitOctant(octant)
+	buckets := make([][]*Star3, 8)
+	for _, star := range starList {
+		i := ChildIndex3(octant, star.position)
+		buckets[i] = append(buckets[i], star)
+	}
+
+	children := make([]*Node3, 8)
+	for i := range children {
+		children[i] = buildNode3AtDepth(subOctants[i], buckets[i], depth+1, leafCapacity)
+	}
+
+	return &Node3{children: children, star: ClusterStar3(starList), sector: octant}
+}
+
+// CalculateNetForce3 takes as input a node of an octree (normally the root), a star and the
+// parameters of the tree walk (theta and softening). It returns the net force exerted on the star
+// by the stars under node.
+func CalculateNetForce3(node *Node3, currStar *Star3, params ForceParameters) Vector3 {
+	var netForce Vector3
+	if node == nil || node.star == nil || currStar == nil {
+		return netForce
+	}
+
+	// a single star
+	if node.children == nil && node.stars == nil {
+		if node.star == currStar {
+			return netForce
+		}
+		return CalcSoftenedForce3(currStar, node.star, G, params.softening)
+	}
+
+	// far enough away to be treated as one point
+	d := CalcDistance3(currStar.position, node.star.position)
+	if !slices.Contains(node.stars, currStar) && d > 0 && node.sector.width/d <= params.theta {
+		return CalcSoftenedForce3(currStar, node.star, G, params.softening)
+	}
+
+	if node.stars != nil {
+		for _, star := range node.stars {
+			if star == currStar {
+				continue
+			}
+			f := CalcSoftenedForce3(currStar, star, G, params.softening)
+			netForce.x += f.x
+			netForce.y += f.y
+			netForce.z += f.z
+		}
+		return netForce
+	}
+
+	for _, child := range node.children {
+		f := CalculateNetForce3(child, currStar, params)
+		netForce.x += f.x
+		netForce.y += f.y
+		netForce.z += f.z
+	}
+	return netForce
+}
+
+// CalcSoftenedForce3 takes as input two stars, the gravity constant and a softening.
+// It returns the force exerted on star1 by star2.
+func CalcSoftenedForce3(s1, s2 *Star3, G float64, soft Softening) Vector3 {
+	var force Vector3
+	d := CalcDistance3(s1.position, s2.position)
+	if d == 0 {
+		return force
+	}
+
+	kernel := soft.kernel
+	if soft.length == 0 {
+		kernel = SofteningNone
+	}
+	F := G * s1.mass * s2.mass * KernelFactor(d, soft.length, kernel)
+	force.x = F * (s2.position.x - s1.position.x)
+	force.y = F * (s2.position.y - s1.position.y)
+	force.z = F * (s2.position.z - s1.position.z)
+	return force
+}
+
+// CalcDistance3 takes as input two points in space and returns the distance between them.
+func CalcDistance3(p1, p2 Vector3) float64 {
+	deltaX := p1.x - p2.x
+	deltaY := p1.y - p2.y
+	deltaZ := p1.z - p2.z
+	return math.Sqrt(deltaX*deltaX + deltaY*deltaY + deltaZ*deltaZ)
+}
+
+// ChildIndex3 takes as input an octant and a point inside it, and returns the index of the
+// sub-octant holding the point: bit 0 is set for the upper half in x, bit 1 in y and bit 2 in z.
+func ChildIndex3(parent Octant, p Vector3) int {
+	mid := parent.width / 2
+	index := 0
+	if p.x >= parent.x+mid {
+		index |= 1
+	}
+	if p.y >= parent.y+mid {
+		index |= 2
+	}
+	if p.z >= parent.z+mid {
+		index |= 4
+	}
+	return index
+}
+
+// SplitOctant takes as input an octant and returns its eight sub-octants, in ChildIndex3 order.
+func SplitOctant(octant Octant) []Octant {
+	mid := octant.width / 2
+	subOctants := make([]Octant, 8)
+	for i := range subOctants {
+		subOctants[i] = Octant{
+			x:     octant.x + float64(i&1)*mid,
+			y:     octant.y + float64(i>>1&1)*mid,
+			z:     octant.z + float64(i>>2&1)*mid,
+			width: mid,
+		}
+	}
+	return subOctants
+}
+
+// CanSplitOctant returns false if splitting an octant would give sub-octants no smaller than it
+// in float64 arithmetic.
+func CanSplitOctant(octant Octant) bool {
+	mid := octant.width / 2
+	return mid > 0 &&
+		octant.x+mid > octant.x && octant.x+mid < octant.x+octant.width &&
+		octant.y+mid > octant.y && octant.y+mid < octant.y+octant.width &&
+		octant.z+mid > octant.z && octant.z+mid < octant.z+octant.width
+}
+
+// CountStarsInOctant takes as input an octant and a slice of star pointers, and returns the stars
+// inside the octant (which, like a Quadrant, includes its lower faces but not its upper ones).
+func CountStarsInOctant(octant Octant, stars []*Star3) []*Star3 {
+	var starList []*Star3
+	for _, star := range stars {
+		p := star.position
+		if p.x >= octant.x && p.x < octant.x+octant.width &&
+			p.y >= octant.y && p.y < octant.y+octant.width &&
+			p.z >= octant.z && p.z < octant.z+octant.width {
+			starList = append(starList, star)
+		}
+	}
+	return starList
+}
+
+// BoundingOctant takes as input a slice of star pointers and returns a cube containing all of them,
+// padded like BoundingQuadrant so that no star lies on its upper faces.
+func BoundingOctant(stars []*Star3) Octant {
+	if len(stars) == 0 {
+		return Octant{}
+	}
+
+	lo, hi := stars[0].position, stars[0].position
+	for _, star := range stars {
+		p := star.position
+		lo = Vector3{math.Min(lo.x, p.x), math.Min(lo.y, p.y), math.Min(lo.z, p.z)}
+		hi = Vector3{math.Max(hi.x, p.x), math.Max(hi.y, p.y), math.Max(hi.z, p.z)}
+	}
+
+	side := math.Max(hi.x-lo.x, math.Max(hi.y-lo.y, hi.z-lo.z))
+	if side == 0 {
+		// all stars sit on one point; any positive width will hold them
+		side = math.Max(math.Abs(lo.x), math.Max(math.Abs(lo.y), math.Abs(lo.z)))
+		if side == 0 {
+			side = 1
+		}
+	}
+	extent := math.Max(math.Max(math.Abs(lo.x), math.Abs(hi.x)), math.Max(math.Max(math.Abs(lo.y), math.Abs(hi.y)), math.Max(math.Abs(lo.z), math.Abs(hi.z))))
+	side = paddedSide(side, extent)
+
+	return Octant{
+		x:     (lo.x+hi.x)/2 - side/2,
+		y:     (lo.y+hi.y)/2 - side/2,
+		z:     (lo.z+hi.z)/2 - side/2,
+		width: side,
+	}
+}
+
+// ClusterStar3 takes as input a slice of star pointers and returns the dummy star standing in for
+// all of them: their total mass placed at their center of mass.
+func ClusterStar3(stars []*Star3) *Star3 {
+	return &Star3{position: CenterOfMass3(stars), mass: SumStarMasses3(stars)}
+}
+
+// SumStarMasses3 takes as input a slice of star pointers and returns the sum of their masses.
+func SumStarMasses3(stars []*Star3) float64 {
+	sumMass := 0.0
+	for _, star := range stars {
+		sumMass += star.mass
+	}
+	return sumMass
+}
+
+// CenterOfMass3 takes as input a slice of star pointers and returns their center of mass.
+func CenterOfMass3(stars []*Star3) Vector3 {
+	var center Vector3
+	sumMass := SumStarMasses3(stars)
+	if sumMass == 0 {
+		return center
+	}
+
+	for _, star := range stars {
+		center.x += star.position.x * star.mass
+		center.y += star.position.y * star.mass
+		center.z += star.position.z * star.mass
+	}
+	center.x /= sumMass
+	center.y /= sumMass
+	center.z /= sumMass
+	return center
+}
+
+// CopyUniverse3 takes as input a 3D universe and returns a pointer to a deep copy of it.
+func CopyUniverse3(currentUniverse *Universe3) *Universe3 {
+	newUniverse := *currentUniverse
+	newUniverse.stars = make([]*Star3, len(currentUniverse.stars))
+	for i, s := range currentUniverse.stars {
+		newUniverse.stars[i] = CopyStar3(s)
+	}
+	return &newUniverse
+}
+
+// CopyStar3 takes as input a star and returns a pointer to a new star with the same fields.
+func CopyStar3(s *Star3) *Star3 {
+	s2 := *s
+	return &s2
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+)
+
+func TestOctantIndex(t *testing.T) {
+    parent := Octant{0, 0, 0, 2}
+    subOctants := SplitOctant(parent)
+    points := []Vector3{
+        {0.5, 0.5, 0.5}, {1.5, 0.5, 0.5}, {0.5, 1.5, 0.5}, {1.5, 1.5, 0.5},
+        {0.5, 0.5, 1.5}, {1.5, 0.5, 1.5}, {0.5, 1.5, 1.5}, {1, 1, 1},
+    }
+    want := []int{0, 1, 2, 3, 4, 5, 6, 7}
+    for i, p := range points {
+        got := ChildIndex3(parent, p)
+        if got != want[i] {
+            t.Errorf("ChildIndex3(%v) = %d, want %d", p, got, want[i])
+        }
+        // every point lies in the sub-octant it is sent to
+        if len(CountStarsInOctant(subOctants[got], []*Star3{{position: p}})) != 1 {
+            t.Errorf("%v is not inside sub-octant %d = %v", p, got, subOctants[got])
+        }
+    }
+}
+
+func TestOctreeForces(t *testing.T) {
+    rng := rand.New(rand.NewSource(11))
+    u := &Universe3{width: 1e18}
+    for i := 0; i < 500; i++ {
+        u.stars = append(u.stars, &Star3{
+            position: Vector3{rng.Float64() * 1e18, rng.Float64() * 1e18, rng.Float64() * 1e18},
+            mass:     solarMass * (0.5 + rng.Float64()),
+        })
+    }
+    // two coincident stars share a leaf
+    u.stars = append(u.stars, CopyStar3(u.stars[0]))
+    tree := GenerateOctree(u, 1)
+
+    var meanExact, meanApprox float64
+    for _, s := range u.stars {
+        var want Vector3
+        for _, other := range u.stars {
+            if other != s {
+                f := CalcSoftenedForce3(s, other, G, Softening{})
+                want = Vector3{want.x + f.x, want.y + f.y, want.z + f.z}
+            }
+        }
+        norm := math.Sqrt(want.x*want.x + want.y*want.y + want.z*want.z)
+
+        exact := CalculateNetForce3(tree.root, s, ForceParameters{theta: 0})
+        approx := CalculateNetForce3(tree.root, s, ForceParameters{theta: 0.5})
+        meanExact += CalcDistance3(exact, want) / norm
+        meanApprox += CalcDistance3(approx, want) / norm
+    }
+    meanExact /= float64(len(u.stars))
+    meanApprox /= float64(len(u.stars))
+
+    if meanExact > 1e-12 {
+        t.Errorf("theta 0: mean relative error %v, want rounding only", meanExact)
+    }
+    if meanApprox > 2e-2 {
+        t.Errorf("theta 0.5: mean relative error %v", meanApprox)
+    }
+}
+
+func TestProject(t *testing.T) {
+    u := &Universe3{width: 10, stars: []*Star3{
+        {position: Vector3{2, 3, 9}, red: 1},
+        {position: Vector3{7, 4, 1}, red: 2},
+    }}
+
+    // seen face-on the 2D picture comes out, with the lower star drawn first
+    flat := u.Project(Projection{})
+    if flat.stars[0].red != 2 || flat.stars[0].position != (OrderedPair{7, 4}) || flat.stars[1].position != (OrderedPair{2, 3}) {
+        t.Errorf("face-on projection gave %v, %v", *flat.stars[0], *flat.stars[1])
+    }
+
+    // tipped on its edge, the z axis becomes the screen's vertical and the nearer star is the one with smaller y
+    edge := u.Project(Projection{elevation: math.Pi / 2})
+    near := edge.stars[1]
+    if near.red != 1 || math.Abs(near.position.x-2) > 1e-9 || math.Abs(near.position.y-9) > 1e-9 {
+        t.Errorf("edge-on projection put the nearest star %v", *near)
+    }
+}
+
+func TestReproducible3(t *testing.T) {
+    run := func(seed int64) *Universe3 {
+        rng := rand.New(rand.NewSource(seed))
+        g0 := InitializeGalaxy3(40, 4e21, Vector3{7e22, 2e22, 5e22}, math.Pi/6, 0, rng)
+        g1 := InitializeGalaxy3(40, 4e21, Vector3{3e22, 7e22, 5e22}, -math.Pi/4, math.Pi/3, rng)
+        timePoints := BarnesHut3(InitializeUniverse3([]Galaxy3{g0, g1}, 1e23), 10, 1e15, 0.5, 1)
+        return timePoints[len(timePoints)-1]
+    }
+    // forces are evaluated in parallel, yet the same seed gives the same stars bit for bit
+    a, b, c := run(3), run(3), run(4)
+    for i := range a.stars {
+        if *a.stars[i] != *b.stars[i] {
+            t.Fatalf("star %d differs between runs with the same seed: %v and %v", i, *a.stars[i], *b.stars[i])
+        }
+    }
+    if *a.stars[0] == *c.stars[0] {
+        t.Error("runs with different seeds give the same first star")
+    }
+}
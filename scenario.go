@@ -0,0 +1,704 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gifhelper"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// A scenario file is a JSON description of a whole run: where the bodies come from, the physics,
+// and what to write out. Any number may be given bare, in the scenario's units, or as a string
+// with its own unit, such as "1 Myr" or "30 km/s". For example:
+//
+//	{
+//	  "units": "si",
+//	  "width": "100 kpc",
+//	  "softening": {"kernel": "plummer", "length": 1e20},
+//	  "bodies": [
+//	    {"galaxy": {"stars": 300, "radius": 4e21, "center": [7e22, 2e22]}},
+//	    {"galaxy": {"stars": 300, "radius": 4e21, "center": [3e22, 7e22]}},
+//	    {"file": "moons.txt"}
+//	  ],
+//	  "push": "5 km/s",
+//	  "seed": 42,
+//	  "simulation": {"generations": 100000, "dt": 1e15, "theta": 0.5, "integrator": "verlet"},
+//	  "output": {"stride": 1000, "frequency": 1, "gif": "collision", "canvasWidth": 1400,
+//	             "scalingFactor": 1.5e11, "diagnostics": "collision_diagnostics.csv"}
+//	}
+//
+// Files are body files (see bodyfile.go), found relative to the scenario file; output files are
+// written relative to the working directory, like those of the built-in commands. The universe takes
+// its width, softening and boundary from the scenario, or else from the first file that has them.
+// Besides files and the galaxies of InitializeGalaxy, bodies may be exponential disks in equilibrium
+// (see DiskSpec).
+// The fields of these types are exported only so encoding/json can fill them in.
+
+// Scenario is a whole run as read from a scenario file.
+type Scenario struct {
+	Units      string         `json:"units,omitzero"` // si (the default), astronomical or galactic
+	Width      *Quantity      `json:"width,omitzero"`
+	Softening  *SofteningSpec `json:"softening,omitzero"`
+	Boundary   string         `json:"boundary,omitzero"` // track (the default), remove, wrap or reflect
+	Bodies     []BodySource   `json:"bodies,omitzero"`
+	Push       *Quantity      `json:"push,omitzero"` // speed at which the first two galaxies are sent at each other; see PushVelocity
+	Seed       *int64         `json:"seed,omitzero"` // seed of the galaxies' random layout; drawn afresh for each run if left out
+	Simulation SimulationSpec `json:"simulation,omitzero"`
+	Output     OutputSpec     `json:"output,omitzero"`
+
+	dir string // directory of the scenario file, which relative paths start from
+}
+
+// SofteningSpec is the softening of a scenario: a kernel (none, plummer or spline) and a length.
+type SofteningSpec struct {
+	Kernel string   `json:"kernel,omitzero"`
+	Length Quantity `json:"length,omitzero"`
+}
+
+// BodySource is one entry of a scenario's bodies: a body file, or a galaxy or disk to generate.
+type BodySource struct {
+	File   string      `json:"file,omitzero"`
+	Galaxy *GalaxySpec `json:"galaxy,omitzero"`
+	Disk   *DiskSpec   `json:"disk,omitzero"`
+}
+
+// GalaxySpec describes a galaxy made by InitializeGalaxy.
+type GalaxySpec struct {
+	Stars  int         `json:"stars,omitzero"`
+	Radius Quantity    `json:"radius,omitzero"`
+	Center [2]Quantity `json:"center,omitzero"`
+}
+
+// DiskSpec describes an exponential disk galaxy made by InitializeDisk. The black hole defaults to
+// the one InitializeGalaxy uses and can be removed with a mass of 0. The halo is optional.
+type DiskSpec struct {
+	Stars       int         `json:"stars,omitzero"`
+	Center      [2]Quantity `json:"center,omitzero"`
+	BlackHole   *Quantity   `json:"blackHole,omitzero"`
+	Mass        Quantity    `json:"mass,omitzero"` // of all the disk's stars together
+	ScaleLength Quantity    `json:"scaleLength,omitzero"`
+	Truncation  Quantity    `json:"truncation,omitzero"`
+	Dispersion  Quantity    `json:"dispersion,omitzero"` // default 0, for circular orbits
+	Halo        *HaloSpec   `json:"halo,omitzero"`
+}
+
+// HaloSpec describes the Hernquist Halo around a disk's center: its total mass and scale radius.
+// A disk with a halo can't be pushed, as the halo stays where it is.
+type HaloSpec struct {
+	Mass  Quantity `json:"mass,omitzero"`
+	Scale Quantity `json:"scale,omitzero"`
+}
+
+// SimulationSpec holds the physics of a scenario. Fields left out take the zero value of the
+// matching BarnesHutSolver field, except theta, which must be given for the Barnes-Hut solver.
+type SimulationSpec struct {
+	Generations    int      `json:"generations,omitzero"`
+	Dt             Quantity `json:"dt,omitzero"`
+	Solver         string   `json:"solver,omitzero"` // barneshut (the default) or direct
+	Theta          *float64 `json:"theta,omitzero"`
+	LeafCapacity   int      `json:"leafCapacity,omitzero"` // default 1
+	Quadrupole     bool     `json:"quadrupole,omitzero"`
+	Criterion      string   `json:"criterion,omitzero"` // geometric (the default), boxedge, salmonwarren or relative
+	Tolerance      float64  `json:"tolerance,omitzero"`
+	TreeBuilder    string   `json:"treeBuilder,omitzero"`    // recursive (the default) or morton
+	ReuseSteps     int      `json:"reuseSteps,omitzero"`     // morton tree builder only
+	RefitTolerance float64  `json:"refitTolerance,omitzero"` // morton tree builder only
+	Workers        int      `json:"workers,omitzero"`
+	Integrator     string   `json:"integrator,omitzero"` // verlet (the default), kdk, dkd, yoshida, rk4 or euler
+}
+
+// OutputSpec says what a scenario writes. Every stride-th generation is kept (default 1); every
+// frequency-th kept generation becomes a frame of the GIF (default 1). Empty file names write nothing.
+type OutputSpec struct {
+	Stride          int     `json:"stride,omitzero"`
+	Frequency       int     `json:"frequency,omitzero"`
+	GIF             string  `json:"gif,omitzero"` // name passed to gifhelper.ImagesToGIF
+	CanvasWidth     int     `json:"canvasWidth,omitzero"`
+	ScalingFactor   float64 `json:"scalingFactor,omitzero"`
+	Diagnostics     string  `json:"diagnostics,omitzero"`
+	Trajectory      string  `json:"trajectory,omitzero"`      // .csv, or .jsonl for JSON Lines
+	TrajectoryUnits string  `json:"trajectoryUnits,omitzero"` // si (the default), astronomical or galactic
+	Checkpoint      string  `json:"checkpoint,omitzero"`      // saved every checkpointEvery generations; see RunScenario
+	CheckpointEvery int     `json:"checkpointEvery,omitzero"`
+	Metadata        string  `json:"metadata,omitzero"` // the scenario as run, with its seed, which the run command repeats exactly
+}
+
+// Quantity is a number in a scenario file: a JSON number in the scenario's units, or a string
+// with a unit. It is converted once its dimension is known.
+type Quantity struct {
+	text string
+}
+
+// UnmarshalJSON reads a quantity from a JSON number or string.
+func (q *Quantity) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &q.text)
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("want a number or a string like \"1 Myr\", got %s", data)
+	}
+	q.text = number.String()
+	return nil
+}
+
+// MarshalJSON writes a quantity as it was read: a JSON number if it is a bare number, else a string.
+func (q Quantity) MarshalJSON() ([]byte, error) {
+	if _, err := strconv.ParseFloat(q.text, 64); err == nil {
+		return []byte(q.text), nil
+	}
+	return json.Marshal(q.text)
+}
+
+// SI returns a quantity in SI units, reading bare numbers in the unit system us.
+func (q Quantity) SI(d Dimension, us UnitSystem) (float64, error) {
+	return ParseQuantity(q.text, d, us)
+}
+
+// LoadScenario reads the scenario file at path.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseScenario(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.dir = filepath.Dir(path)
+	return s, nil
+}
+
+// ParseScenario reads a scenario from JSON. Unknown fields are errors, so typos don't go unnoticed.
+func ParseScenario(data []byte) (*Scenario, error) {
+	var s Scenario
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// units returns the unit system of the scenario's bare numbers.
+func (s *Scenario) units() (UnitSystem, error) {
+	return unitSystemOrSI(s.Units)
+}
+
+// unitSystemOrSI is UnitSystemByName with SI for an empty name and an error for an unknown one.
+func unitSystemOrSI(name string) (UnitSystem, error) {
+	if name == "" {
+		return SIUnits, nil
+	}
+	us, ok := UnitSystemByName(name)
+	if !ok {
+		return UnitSystem{}, fmt.Errorf("unknown unit system %q", name)
+	}
+	return us, nil
+}
+
+// Universe builds the initial universe of a scenario, loading its files and generating its galaxies
+// in order. A scenario without a seed is given a random one first, so the run can be repeated.
+func (s *Scenario) Universe() (*Universe, error) {
+	us, err := s.units()
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Bodies) == 0 {
+		return nil, errors.New("bodies: no bodies")
+	}
+
+	if s.Seed == nil {
+		seed := rand.Int63()
+		s.Seed = &seed
+	}
+	rng := rand.New(rand.NewSource(*s.Seed))
+
+	u := &Universe{}
+	var fromFile *Universe // first file, for the settings the scenario leaves out
+	var galaxies []Galaxy
+	var sources []BodySource // that each galaxy was made from
+	for i, source := range s.Bodies {
+		given := 0
+		for _, set := range []bool{source.File != "", source.Galaxy != nil, source.Disk != nil} {
+			if set {
+				given++
+			}
+		}
+		switch {
+		case given != 1:
+			return nil, fmt.Errorf("bodies[%d]: want exactly one of file, galaxy and disk", i)
+		case source.File != "":
+			path := source.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(s.dir, path)
+			}
+			loaded, err := LoadBodies(path)
+			if err != nil {
+				return nil, fmt.Errorf("bodies[%d]: %w", i, err)
+			}
+			if fromFile == nil {
+				fromFile = loaded
+			}
+			u.stars = append(u.stars, loaded.stars...)
+		case source.Galaxy != nil:
+			g := source.Galaxy
+			if g.Stars < 1 {
+				return nil, fmt.Errorf("bodies[%d].galaxy.stars: want at least 1, got %d", i, g.Stars)
+			}
+			r, err := g.Radius.SI(DimLength, us)
+			if err != nil {
+				return nil, fmt.Errorf("bodies[%d].galaxy.radius: %w", i, err)
+			}
+			x, err := g.Center[0].SI(DimLength, us)
+			if err != nil {
+				return nil, fmt.Errorf("bodies[%d].galaxy.center: %w", i, err)
+			}
+			y, err := g.Center[1].SI(DimLength, us)
+			if err != nil {
+				return nil, fmt.Errorf("bodies[%d].galaxy.center: %w", i, err)
+			}
+			galaxy := InitializeGalaxy(g.Stars, r, x, y, rng)
+			galaxies, sources = append(galaxies, galaxy), append(sources, source)
+			u.stars = append(u.stars, galaxy...)
+		default:
+			disk, err := source.Disk.Galaxy(us, rng)
+			if err != nil {
+				return nil, fmt.Errorf("bodies[%d].disk.%w", i, err)
+			}
+			galaxies, sources = append(galaxies, disk), append(sources, source)
+			u.stars = append(u.stars, disk...)
+		}
+	}
+
+	if fromFile != nil {
+		u.width, u.softening, u.boundary = fromFile.width, fromFile.softening, fromFile.boundary
+	}
+	if s.Width != nil {
+		if u.width, err = s.Width.SI(DimLength, us); err != nil {
+			return nil, fmt.Errorf("width: %w", err)
+		}
+	}
+	if u.width <= 0 {
+		return nil, errors.New("width: want a positive width")
+	}
+	if s.Softening != nil {
+		kernel, ok := softeningKernels[s.Softening.Kernel]
+		if !ok {
+			return nil, fmt.Errorf("softening.kernel: unknown kernel %q", s.Softening.Kernel)
+		}
+		length, err := s.Softening.Length.SI(DimLength, us)
+		if err != nil {
+			return nil, fmt.Errorf("softening.length: %w", err)
+		}
+		u.softening = Softening{kernel: kernel, length: length}
+	}
+	if s.Boundary != "" {
+		policy, ok := boundaryNames[s.Boundary]
+		if !ok {
+			return nil, fmt.Errorf("boundary: unknown boundary %q", s.Boundary)
+		}
+		u.boundary = policy
+	}
+
+	if s.Push != nil {
+		if len(galaxies) < 2 {
+			return nil, errors.New("push: needs two galaxies")
+		}
+		speed, err := s.Push.SI(DimVelocity, us)
+		if err != nil {
+			return nil, fmt.Errorf("push: %w", err)
+		}
+		for _, source := range sources[:2] {
+			if source.Disk != nil && source.Disk.Halo != nil {
+				return nil, errors.New("push: can't push a disk with a halo, which stays where it is")
+			}
+		}
+		// a disk keeps its rotation and moves off as a whole; a plain galaxy is set moving as
+		// PushGalaxies does, whatever it is paired with
+		v := PushVelocity(galaxies[0], galaxies[1], speed)
+		for k, g := range galaxies[:2] {
+			for _, star := range g {
+				if sources[k].Disk == nil {
+					star.velocity = OrderedPair{}
+				}
+				star.velocity.x += v.x
+				star.velocity.y += v.y
+			}
+			v = OrderedPair{-v.x, -v.y}
+		}
+	}
+	return u, nil
+}
+
+// Galaxy checks a disk's settings, reading bare numbers in the unit system us, and generates it
+// with rng. Its errors start with the name of the bad setting.
+func (d *DiskSpec) Galaxy(us UnitSystem, rng *rand.Rand) (Galaxy, error) {
+	if d.Stars < 1 {
+		return nil, fmt.Errorf("stars: want at least 1, got %d", d.Stars)
+	}
+	center, p, err := d.parameters(us)
+	if err != nil {
+		return nil, err
+	}
+	return InitializeDisk(d.Stars, center, p, rng), nil
+}
+
+// parameters checks a disk's settings other than its number of stars, reading bare numbers in the
+// unit system us, and returns its center and its parameters for InitializeDisk.
+func (d *DiskSpec) parameters(us UnitSystem) (OrderedPair, DiskParameters, error) {
+	var center OrderedPair
+	p := DiskParameters{blackHole: blackHoleMass}
+	var err error
+	read := func(name string, q *Quantity, dim Dimension, value *float64) {
+		if err != nil || q == nil {
+			return
+		}
+		if *value, err = q.SI(dim, us); err != nil {
+			err = fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	read("center", &d.Center[0], DimLength, &center.x)
+	read("center", &d.Center[1], DimLength, &center.y)
+	read("blackHole", d.BlackHole, DimMass, &p.blackHole)
+	read("mass", &d.Mass, DimMass, &p.diskMass)
+	read("scaleLength", &d.ScaleLength, DimLength, &p.scaleLength)
+	read("truncation", &d.Truncation, DimLength, &p.truncation)
+	if d.Dispersion.text != "" {
+		read("dispersion", &d.Dispersion, DimVelocity, &p.dispersion)
+	}
+	if d.Halo != nil {
+		read("halo.mass", &d.Halo.Mass, DimMass, &p.haloMass)
+		read("halo.scale", &d.Halo.Scale, DimLength, &p.haloScale)
+	}
+
+	switch {
+	case err != nil:
+	case p.blackHole < 0:
+		err = errors.New("blackHole: can't be negative")
+	case p.diskMass <= 0:
+		err = errors.New("mass: want a positive mass")
+	case p.scaleLength <= 0 || p.truncation <= 0:
+		err = errors.New("scaleLength, truncation: want positive lengths")
+	case p.dispersion < 0:
+		err = errors.New("dispersion: can't be negative")
+	case d.Halo != nil && (p.haloMass <= 0 || p.haloScale <= 0):
+		err = errors.New("halo: want a positive mass and scale")
+	}
+	return center, p, err
+}
+
+var softeningKernels = map[string]SofteningKernel{
+	"none":    SofteningNone,
+	"plummer": SofteningPlummer,
+	"spline":  SofteningSpline,
+}
+
+var openingCriteria = map[string]OpeningCriterion{
+	"":             CriterionGeometric,
+	"geometric":    CriterionGeometric,
+	"boxedge":      CriterionBoxEdge,
+	"salmonwarren": CriterionSalmonWarren,
+	"relative":     CriterionRelative,
+}
+
+var treeBuilders = map[string]TreeBuilder{
+	"":          TreeBuilderRecursive,
+	"recursive": TreeBuilderRecursive,
+	"morton":    TreeBuilderMorton,
+}
+
+var integratorNames = map[string]Integrator{
+	"":        VerletIntegrator{},
+	"verlet":  VerletIntegrator{},
+	"kdk":     KDKIntegrator{},
+	"dkd":     DKDIntegrator{},
+	"yoshida": YoshidaIntegrator{},
+	"rk4":     RK4Integrator{},
+	"euler":   EulerIntegrator{},
+}
+
+// Solver returns the force solver a scenario asks for, in a HaloSolver if any of its disks has a halo.
+func (s *Scenario) Solver() (ForceSolver, error) {
+	solver, err := s.starSolver()
+	if err != nil {
+		return nil, err
+	}
+	halos, err := s.halos()
+	if err != nil {
+		return nil, err
+	}
+	if len(halos) > 0 {
+		solver = HaloSolver{solver: solver, halos: halos}
+	}
+	return solver, nil
+}
+
+// halos returns the halos of a scenario's disks.
+func (s *Scenario) halos() ([]Halo, error) {
+	us, err := s.units()
+	if err != nil {
+		return nil, err
+	}
+	var halos []Halo
+	for i, source := range s.Bodies {
+		if source.Disk == nil || source.Disk.Halo == nil {
+			continue
+		}
+		center, p, err := source.Disk.parameters(us)
+		if err != nil {
+			return nil, fmt.Errorf("bodies[%d].disk.%w", i, err)
+		}
+		halos = append(halos, Halo{center: center, mass: p.haloMass, scale: p.haloScale})
+	}
+	return halos, nil
+}
+
+// starSolver returns the solver for the gravity of the stars on each other a scenario asks for.
+func (s *Scenario) starSolver() (ForceSolver, error) {
+	sim := s.Simulation
+	if sim.Workers < 0 {
+		return nil, fmt.Errorf("simulation.workers: want 0 (all CPUs) or more, got %d", sim.Workers)
+	}
+	switch sim.Solver {
+	case "direct":
+		return DirectSolver{workers: sim.Workers}, nil
+	case "", "barneshut":
+	default:
+		return nil, fmt.Errorf("simulation.solver: unknown solver %q", sim.Solver)
+	}
+
+	if sim.Theta == nil || *sim.Theta < 0 {
+		return nil, errors.New("simulation.theta: want a theta of 0 or more")
+	}
+	criterion, ok := openingCriteria[sim.Criterion]
+	if !ok {
+		return nil, fmt.Errorf("simulation.criterion: unknown criterion %q", sim.Criterion)
+	}
+	builder, ok := treeBuilders[sim.TreeBuilder]
+	if !ok {
+		return nil, fmt.Errorf("simulation.treeBuilder: unknown tree builder %q", sim.TreeBuilder)
+	}
+	if sim.LeafCapacity < 0 || sim.ReuseSteps < 0 || sim.RefitTolerance < 0 {
+		return nil, errors.New("simulation: leafCapacity, reuseSteps and refitTolerance can't be negative")
+	}
+	if builder != TreeBuilderMorton && (sim.ReuseSteps > 0 || sim.RefitTolerance > 0) {
+		return nil, errors.New("simulation: reuseSteps and refitTolerance need the morton treeBuilder")
+	}
+	return &BarnesHutSolver{
+		theta:          *sim.Theta,
+		leafCapacity:   max(sim.LeafCapacity, 1),
+		quadrupole:     sim.Quadrupole,
+		criterion:      criterion,
+		tolerance:      sim.Tolerance,
+		workers:        sim.Workers,
+		builder:        builder,
+		reuseSteps:     sim.ReuseSteps,
+		refitTolerance: sim.RefitTolerance,
+	}, nil
+}
+
+// Integrator returns the integrator a scenario asks for.
+func (s *Scenario) Integrator() (Integrator, error) {
+	integrator, ok := integratorNames[s.Simulation.Integrator]
+	if !ok {
+		return nil, fmt.Errorf("simulation.integrator: unknown integrator %q", s.Simulation.Integrator)
+	}
+	return integrator, nil
+}
+
+// ErrCheckpointMismatch is returned by RunScenario when the checkpoint it would resume from was
+// saved by a run of another scenario.
+var ErrCheckpointMismatch = errors.New("checkpoint is of another run")
+
+// RunScenario runs a scenario and writes everything its output section asks for.
+// Bad settings are reported before the simulation starts.
+// If the scenario's checkpoint file exists, the interrupted run that saved it is carried on from
+// there, provided it ran the same scenario (all but its output settings and workers), and its
+// trajectory is appended to; the GIF and diagnostics then only cover the generations from the
+// checkpoint on.
+// A checkpoint of another scenario is an error, and is left for the user to delete.
+func RunScenario(s *Scenario) error {
+	us, err := s.units()
+	if err != nil {
+		return fmt.Errorf("units: %w", err)
+	}
+	solver, err := s.Solver()
+	if err != nil {
+		return err
+	}
+	integrator, err := s.Integrator()
+	if err != nil {
+		return err
+	}
+	dt, err := s.Simulation.Dt.SI(DimTime, us)
+	if err != nil {
+		return fmt.Errorf("simulation.dt: %w", err)
+	}
+	if s.Simulation.Generations < 1 || dt <= 0 {
+		return errors.New("simulation: want at least 1 generation and a positive dt")
+	}
+	out := s.Output
+	stride, frequency := max(out.Stride, 1), max(out.Frequency, 1)
+	if out.GIF != "" && (out.CanvasWidth < 1 || out.ScalingFactor <= 0) {
+		return errors.New("output: a GIF needs a positive canvasWidth and scalingFactor")
+	}
+	trajectoryUnits, err := unitSystemOrSI(out.TrajectoryUnits)
+	if err != nil {
+		return fmt.Errorf("output.trajectoryUnits: %w", err)
+	}
+	seedGiven := s.Seed != nil
+	fingerprint, err := s.fingerprint()
+	if err != nil {
+		return err
+	}
+	initialUniverse, err := s.Universe()
+	if err != nil {
+		return err
+	}
+
+	run := Checkpoint{universe: initialUniverse, numGens: s.Simulation.Generations, time: dt, solver: solver, integrator: integrator, seed: *s.Seed, seeded: true, scenario: fingerprint}
+	every, resumed := 0, false
+	if out.Checkpoint != "" {
+		every = out.CheckpointEvery
+		// carry on from a checkpoint an interrupted run left behind, if there is one
+		if _, statErr := os.Stat(out.Checkpoint); statErr == nil {
+			saved, err := LoadCheckpoint(out.Checkpoint)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(saved.scenario, fingerprint) || !saved.seeded || seedGiven && saved.seed != *s.Seed {
+				return fmt.Errorf("%s: %w; delete it to start afresh", out.Checkpoint, ErrCheckpointMismatch)
+			}
+			// the checkpoint's run is this scenario with the checkpoint's seed, so that is what the
+			// metadata records
+			run, resumed = saved, true
+			s.Seed = &run.seed
+			// the same solver but for its workers, which are this run's to choose
+			run.solver = solver
+			fmt.Println("Resuming from", out.Checkpoint, "at generation", run.universe.step)
+		}
+	}
+
+	var trajectory *TrajectoryWriter
+	if out.Trajectory != "" {
+		var f *os.File
+		if resumed {
+			trajectory, f, err = AppendTrajectory(out.Trajectory, run.universe.step, dt, trajectoryUnits)
+		} else if f, err = os.Create(out.Trajectory); err == nil {
+			trajectory = NewTrajectoryWriter(f, TrajectoryFormatOf(out.Trajectory), dt, trajectoryUnits)
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	var frames []*Universe
+	keep := func(u *Universe) {
+		frames = append(frames, CopyUniverse(u))
+		if trajectory != nil {
+			trajectory.Visit(u)
+			// so that no checkpoint is ever ahead of the trajectory on disk
+			trajectory.Flush()
+		}
+	}
+
+	fmt.Println("Starting simulation with", len(run.universe.stars), "stars and seed", *s.Seed)
+	if out.Metadata != "" {
+		if err := s.SaveMetadata(out.Metadata); err != nil {
+			return err
+		}
+	}
+	err = SimulateCheckpointed(run, stride, every, out.Checkpoint, keep)
+	if err == nil && out.Checkpoint != "" {
+		os.Remove(out.Checkpoint)
+	}
+	if err != nil {
+		return err
+	}
+	if trajectory != nil {
+		if err := trajectory.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if out.Diagnostics != "" {
+		fmt.Println("Simulation run. Now recording diagnostics.")
+		theta := 0.0
+		if bh := treeSolver(solver); bh != nil {
+			theta = bh.theta
+		}
+		if err := SaveDiagnostics(frames, theta, out.Diagnostics); err != nil {
+			return err
+		}
+	}
+	if out.GIF != "" {
+		fmt.Println("Now drawing images.")
+		images := AnimateSystem(frames, out.CanvasWidth, frequency, out.ScalingFactor)
+		fmt.Println("Images drawn. Now generating GIF.")
+		gifhelper.ImagesToGIF(images, out.GIF)
+		fmt.Println("GIF drawn.")
+	}
+	return nil
+}
+
+// SaveMetadata writes the scenario to path as JSON, with its body files as absolute paths, so
+// that running the written file repeats the run exactly.
+func (s *Scenario) SaveMetadata(path string) error {
+	written, err := s.withAbsolutePaths()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(&written, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// fingerprint returns what decides the course of a scenario's run as JSON: everything but its
+// output settings, its seed, which is checked on its own, and its number of workers, which
+// doesn't change the results. Its body files are given as absolute paths.
+func (s *Scenario) fingerprint() ([]byte, error) {
+	run, err := s.withAbsolutePaths()
+	if err != nil {
+		return nil, err
+	}
+	run.Seed, run.Output = nil, OutputSpec{}
+	run.Simulation.Workers = 0
+	return json.Marshal(&run)
+}
+
+// withAbsolutePaths returns a copy of the scenario whose body files are absolute paths.
+func (s *Scenario) withAbsolutePaths() (Scenario, error) {
+	c := *s
+	c.Bodies = slices.Clone(s.Bodies)
+	for i, source := range c.Bodies {
+		if source.File != "" && !filepath.IsAbs(source.File) {
+			abs, err := filepath.Abs(filepath.Join(s.dir, source.File))
+			if err != nil {
+				return Scenario{}, err
+			}
+			c.Bodies[i].File = abs
+		}
+	}
+	return c, nil
+}
+
+// RunScenarioFile loads the scenario file at path and runs it.
+func RunScenarioFile(path string) error {
+	s, err := LoadScenario(path)
+	if err != nil {
+		return err
+	}
+	if err := RunScenario(s); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestBuiltInScenarios(t *testing.T) {
+    for command, want := range map[string]int{"jupiter": 5, "galaxy": 501, "collision": 602, "disk": 1001} {
+        s, err := LoadScenario(BuiltInScenario(command))
+        if err != nil {
+            t.Fatalf("%s: %v", command, err)
+        }
+        u, err := s.Universe()
+        if err != nil {
+            t.Fatalf("%s: %v", command, err)
+        }
+        if len(u.stars) != want {
+            t.Errorf("%s: %d bodies, want %d", command, len(u.stars), want)
+        }
+        if _, err := s.Solver(); err != nil {
+            t.Errorf("%s: %v", command, err)
+        }
+        if _, err := s.Integrator(); err != nil {
+            t.Errorf("%s: %v", command, err)
+        }
+    }
+
+    s, err := LoadScenario(BuiltInScenario("jupiter"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    u, err := s.Universe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if u.stars[1].name != "Io" || u.width <= 0 {
+        t.Errorf("jupiter: second body %q, width %v; want Io and the width from the body file", u.stars[1].name, u.width)
+    }
+}
+
+func TestPushGalaxies(t *testing.T) {
+    const disk = `{"disk": {"stars": 50, "center": [80, 50], "mass": 1e10, "scaleLength": 3, "truncation": 10}}`
+    const galaxy = `{"galaxy": {"stars": 50, "radius": 10, "center": [20, 50]}}`
+    universe := func(first, push string) *Universe {
+        s, err := ParseScenario([]byte(`{
+            "units": "galactic",
+            "width": 100,
+            "bodies": [` + first + `, ` + disk + `],` + push + `
+            "seed": 3
+        }`))
+        if err != nil {
+            t.Fatal(err)
+        }
+        u, err := s.Universe()
+        if err != nil {
+            t.Fatal(err)
+        }
+        return u
+    }
+
+    // a disk keeps its rotation and moves along the line between the galaxies on top of it;
+    // a plain galaxy is set moving as PushGalaxies does, even next to a disk
+    for _, first := range []string{strings.Replace(disk, "80", "20", 1), galaxy} {
+        still, pushed := universe(first, ""), universe(first, `"push": "5 km/s",`)
+        half := len(still.stars) / 2
+        v := PushVelocity(still.stars[:half], still.stars[half:], 5e3)
+        for i, s := range pushed.stars {
+            want := OrderedPair{still.stars[i].velocity.x + v.x, still.stars[i].velocity.y + v.y}
+            if i >= half {
+                want = OrderedPair{still.stars[i].velocity.x - v.x, still.stars[i].velocity.y - v.y}
+            } else if first == galaxy {
+                want = v
+            }
+            if math.Abs(s.velocity.x-want.x) > 1e-6 || math.Abs(s.velocity.y-want.y) > 1e-6 {
+                t.Fatalf("%s and a disk: star %d moves at %v after the push, want %v", first, i, s.velocity, want)
+            }
+        }
+    }
+}
+
+func TestDiskHalo(t *testing.T) {
+    s, err := ParseScenario([]byte(`{
+        "units": "galactic",
+        "width": 100,
+        "bodies": [{"disk": {"stars": 50, "center": [50, 50], "mass": 1e9, "scaleLength": 3, "truncation": 10,
+                             "halo": {"mass": "1e11 Msun", "scale": 10}}}],
+        "simulation": {"generations": 1, "dt": 1, "theta": 0.5}
+    }`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.Universe(); err != nil {
+        t.Fatal(err)
+    }
+    solver, err := s.Solver()
+    if err != nil {
+        t.Fatal(err)
+    }
+    const kpc = 1e3 * parsec
+    want := Halo{center: OrderedPair{50 * kpc, 50 * kpc}, mass: 1e11 * solarMass, scale: 10 * kpc}
+    halos, ok := solver.(HaloSolver)
+    if !ok || len(halos.halos) != 1 || treeSolver(solver) == nil {
+        t.Fatalf("solver %+v, want a HaloSolver around a BarnesHutSolver", solver)
+    }
+    if got := halos.halos[0]; math.Abs(got.mass-want.mass) > 1e-9*want.mass || math.Abs(got.scale-want.scale) > 1e-9*want.scale ||
+        math.Abs(got.center.x-want.center.x) > 1e-9*want.center.x {
+        t.Errorf("halo %+v, want %+v", got, want)
+    }
+}
+
+func TestRunScenario(t *testing.T) {
+    dir := t.TempDir()
+    diagnostics := filepath.Join(dir, "diagnostics.csv")
+    trajectory := filepath.Join(dir, "trajectory.jsonl")
+    s, err := ParseScenario([]byte(`{
+        "units": "galactic",
+        "width": 100,
+        "softening": {"kernel": "plummer", "length": "0.1 kpc"},
+        "bodies": [{"galaxy": {"stars": 20, "radius": 4, "center": [50, 50]}}],
+        "simulation": {"generations": 10, "dt": "1 Myr", "theta": 0.5, "integrator": "kdk"},
+        "output": {"stride": 5, "diagnostics": "` + diagnostics + `",
+                   "trajectory": "` + trajectory + `", "trajectoryUnits": "galactic"}
+    }`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := RunScenario(s); err != nil {
+        t.Fatal(err)
+    }
+
+    data, err := os.ReadFile(trajectory)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // generations 0, 5 and 10 of 21 stars (the galaxy and its black hole)
+    lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+    if len(lines) != 3*21 {
+        t.Errorf("trajectory has %d records, want %d", len(lines), 3*21)
+    }
+    if !strings.HasPrefix(lines[len(lines)-1], `{"step":10,"time":10,`) {
+        t.Errorf("last record %s, want step 10 at 10 Myr", lines[len(lines)-1])
+    }
+    if _, err := os.Stat(diagnostics); err != nil {
+        t.Error(err)
+    }
+}
+
+func TestResumeScenario(t *testing.T) {
+    dir := t.TempDir()
+    trajectory := filepath.Join(dir, "trajectory.csv")
+    checkpoint := filepath.Join(dir, "run.ckpt")
+    metadata := filepath.Join(dir, "run.json")
+    scenario := func(seed, theta string) *Scenario {
+        s, err := ParseScenario([]byte(`{
+            "width": 1e23,
+            "bodies": [{"galaxy": {"stars": 20, "radius": 4e21, "center": [5e22, 5e22]}}],` + seed + `
+            "simulation": {"generations": 20, "dt": 1e15, "theta": ` + theta + `},
+            "output": {"stride": 5, "trajectory": "` + trajectory + `", "metadata": "` + metadata + `",
+                       "checkpoint": "` + checkpoint + `", "checkpointEvery": 10}
+        }`))
+        if err != nil {
+            t.Fatal(err)
+        }
+        return s
+    }
+    run := func(s *Scenario) (string, error) {
+        err := RunScenario(s)
+        data, _ := os.ReadFile(trajectory)
+        return string(data), err
+    }
+    // leaves the checkpoint of generation 10 behind, as a run stopped before generation 20 does
+    interrupt := func() {
+        s := scenario(`"seed": 0,`, "0.5")
+        fingerprint, err := s.fingerprint()
+        if err != nil {
+            t.Fatal(err)
+        }
+        u, err := s.Universe()
+        if err != nil {
+            t.Fatal(err)
+        }
+        solver, _ := s.Solver()
+        integrator, _ := s.Integrator()
+        interrupted := Checkpoint{universe: u, numGens: 20, time: 1e15, solver: solver, integrator: integrator, seed: 0, seeded: true, scenario: fingerprint}
+        if err := SimulateCheckpointed(interrupted, 1, 10, checkpoint, func(*Universe) {}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    want, err := run(scenario(`"seed": 0,`, "0.5"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+        t.Errorf("checkpoint left behind by a finished run: %v", err)
+    }
+
+    // the resumed run drops what the interrupted one wrote after its checkpoint, down to the record
+    // it was writing when stopped, and writes it again; without a seed of its own, it takes and
+    // records the checkpoint's; the number of workers may change, as it doesn't change the run
+    interrupt()
+    if err := os.WriteFile(trajectory, []byte(want[:len(want)-5]), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    resumed := scenario("", "0.5")
+    resumed.Simulation.Workers = 3
+    if got, err := run(resumed); err != nil || got != want {
+        t.Errorf("resumed run gave error %v and trajectory\n%s\nwant\n%s", err, got, want)
+    }
+    recorded, err := LoadScenario(metadata)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if recorded.Seed == nil || *recorded.Seed != 0 {
+        t.Errorf("resumed run's metadata has seed %v, want 0", recorded.Seed)
+    }
+
+    // a checkpoint of another scenario or seed isn't resumed, or deleted
+    interrupt()
+    if _, err := run(scenario("", "0.7")); !errors.Is(err, ErrCheckpointMismatch) {
+        t.Errorf("resuming another scenario's checkpoint gave error %v", err)
+    }
+    if _, err := run(scenario(`"seed": 1,`, "0.5")); !errors.Is(err, ErrCheckpointMismatch) {
+        t.Errorf("resuming a checkpoint of another seed gave error %v", err)
+    }
+    if _, err := os.Stat(checkpoint); err != nil {
+        t.Error(err)
+    }
+}
+
+func TestScenarioErrors(t *testing.T) {
+    tests := []struct {
+        json string
+        want string
+    }{
+        {`{"bodies": [], "simulaton": {}}`, `unknown field "simulaton"`},
+        {`{"width": "3 km/s", "bodies": [{"galaxy": {"stars": 1, "radius": 1, "center": [0, 0]}}],
+           "simulation": {"generations": 1, "dt": 1, "theta": 0.5}}`, "width: quantity \"3 km/s\" has dimension"},
+        {`{"width": 10, "bodies": [{"galaxy": {"stars": 1, "radius": 1, "center": [0, 0]}}],
+           "simulation": {"generations": 1, "dt": 1}}`, "simulation.theta"},
+        {`{"width": 10, "bodies": [{"file": "a.txt", "galaxy": {"stars": 1, "radius": 1, "center": [0, 0]}}],
+           "simulation": {"generations": 1, "dt": 1, "solver": "direct"}}`, "bodies[0]: want exactly one"},
+        {`{"width": 10, "bodies": [{"galaxy": {"stars": 1, "radius": 1, "center": [0, 0]}}],
+           "simulation": {"generations": 1, "dt": 1, "solver": "direct", "integrator": "leapfrog"}}`, "unknown integrator"},
+        {`{"width": 10, "bodies": [{"galaxy": {"stars": 1, "radius": 1, "center": [0, 0]}}],
+           "simulation": {"generations": 1, "dt": 1, "theta": 0.5, "reuseSteps": 3}}`, "need the morton treeBuilder"},
+        {`{"width": 10, "bodies": [{"galaxy": {"stars": 1, "radius": 1, "center": [0, 0]}}],
+           "simulation": {"generations": 1, "dt": 1, "theta": 0.5, "treeBuilder": "morton", "refitTolerance": -1}}`, "can't be negative"},
+        {`{"width": 10, "bodies": [{"galaxy": {"stars": 1, "radius": 1, "center": [0, 0]}}],
+           "simulation": {"generations": 1, "dt": 1, "solver": "direct"}, "output": {"gif": "out"}}`, "canvasWidth"},
+        {`{"width": 10, "bodies": [{"disk": {"stars": 10, "center": [5, 5], "mass": 1, "scaleLength": "1 Msun", "truncation": 3}}],
+           "simulation": {"generations": 1, "dt": 1, "solver": "direct"}}`, "bodies[0].disk.scaleLength: quantity \"1 Msun\" has dimension mass"},
+        {`{"width": 10, "bodies": [{"disk": {"stars": 10, "center": [5, 5], "mass": 1, "scaleLength": 1, "truncation": 3,
+           "halo": {"mass": 1, "scale": 0}}}], "simulation": {"generations": 1, "dt": 1, "solver": "direct"}}`, "bodies[0].disk.halo: want a positive mass and scale"},
+        {`{"width": 10, "bodies": [{"disk": {"stars": 10, "center": [2, 5], "mass": 1, "scaleLength": 1, "truncation": 3, "halo": {"mass": 1, "scale": 1}}},
+           {"galaxy": {"stars": 1, "radius": 1, "center": [8, 5]}}], "push": 1,
+           "simulation": {"generations": 1, "dt": 1, "solver": "direct"}}`, "push: can't push a disk with a halo"},
+    }
+    for _, test := range tests {
+        s, err := ParseScenario([]byte(test.json))
+        if err == nil {
+            err = RunScenario(s)
+        }
+        if err == nil || !strings.Contains(err.Error(), test.want) {
+            t.Errorf("scenario %s: got error %v, want one containing %q", test.json, err, test.want)
+        }
+    }
+}
+
+func TestReproducibleScenario(t *testing.T) {
+    dir := t.TempDir()
+    trajectory := filepath.Join(dir, "trajectory.jsonl")
+    metadata := filepath.Join(dir, "run.json")
+    scenario := func(seed, simulation string) []byte {
+        return []byte(`{
+            "width": 1e23,
+            "softening": {"kernel": "plummer", "length": 1e20},
+            "bodies": [
+                {"galaxy": {"stars": 30, "radius": 4e21, "center": [7e22, 2e22]}},
+                {"galaxy": {"stars": 30, "radius": 4e21, "center": [3e22, 7e22]}}
+            ],
+            "push": "5 km/s",` + seed + `
+            "simulation": {"generations": 20, "dt": "1e15 s", ` + simulation + `},
+            "output": {"stride": 5, "trajectory": "` + trajectory + `", "metadata": "` + metadata + `"}
+        }`)
+    }
+    run := func(data []byte) string {
+        s, err := ParseScenario(data)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := RunScenario(s); err != nil {
+            t.Fatal(err)
+        }
+        out, err := os.ReadFile(trajectory)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return string(out)
+    }
+
+    // a run without a seed records the one it drew, and running the record repeats the run
+    first := run(scenario("", `"theta": 0.5`))
+    recorded, err := os.ReadFile(metadata)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s, err := ParseScenario(recorded)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s.Seed == nil {
+        t.Fatalf("metadata has no seed:\n%s", recorded)
+    }
+    if again := run(recorded); again != first {
+        t.Error("running the metadata of a run doesn't repeat it")
+    }
+    if other := run(scenario(`"seed": 1,`, `"theta": 0.5`)); other == first && *s.Seed != 1 {
+        t.Error("runs with different seeds are the same")
+    }
+
+    // the same seed gives bit-identical trajectories however many workers evaluate the forces
+    seed := fmt.Sprintf(`"seed": %d,`, *s.Seed)
+    for _, simulation := range []string{
+        `"theta": 0.5, "workers": %d`,
+        `"theta": 0.7, "quadrupole": true, "treeBuilder": "morton", "reuseSteps": 3, "workers": %d`,
+        `"solver": "direct", "integrator": "yoshida", "workers": %d`,
+    } {
+        want := run(scenario(seed, fmt.Sprintf(simulation, 1)))
+        for _, workers := range []int{2, 3, 8} {
+            if got := run(scenario(seed, fmt.Sprintf(simulation, workers))); got != want {
+                t.Errorf("%s: trajectory with %d workers differs from the one with 1", simulation, workers)
+            }
+        }
+    }
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ForceSolver computes the gravitational acceleration of every star in a universe.
+// UpdateUniverse calls it once per step, so different ways of computing gravity can be swapped in.
+type ForceSolver interface {
+	// ComputeAccelerations fills accelerations[i] with the acceleration of u.stars[i].
+	// It may record per-step diagnostics on u, but must not move its stars.
+	ComputeAccelerations(u *Universe, accelerations []OrderedPair)
+}
+
+// BarnesHutSolver approximates gravity with a QuadTree: distant groups of stars
+// are replaced by their center of mass whenever sector width / distance <= theta.
+// With quadrupole set, approximated groups also contribute their quadrupole moment,
+// which keeps forces accurate at larger theta.
+type BarnesHutSolver struct {
+	theta        float64
+	leafCapacity int  // most stars held by one tree leaf (summed directly)
+	quadrupole   bool // use quadrupole moments as well as centers of mass
+
+	criterion OpeningCriterion // when a node may be approximated (theta is used by the geometric ones)
+	tolerance float64          // error tolerance for CriterionSalmonWarren and CriterionRelative
+
+	workers    int // goroutines walking the tree; 0 uses every CPU, 1 runs serially
+	buildDepth int // top tree levels whose subtrees are built in parallel; 0 builds serially
+
+	builder TreeBuilder // which kind of tree to build
+	flat    FlatTree    // kept between steps so TreeBuilderMorton can reuse its memory
+
+	// With TreeBuilderMorton the tree can be kept for up to reuseSteps evaluations, refitting its
+	// masses and centers of mass to the moved stars, as long as no star has left its leaf by more
+	// than refitTolerance times the leaf's width. Evaluations since the last full build:
+	reuseSteps     int
+	refitTolerance float64
+	sinceBuild     int
+}
+
+// TreeBuilder selects how BarnesHutSolver builds its tree each step.
+type TreeBuilder int
+
+const (
+	TreeBuilderRecursive TreeBuilder = iota // a *Node per cell, built by BuildNode
+	TreeBuilderMorton                       // a FlatTree built by sorting stars on Morton keys
+)
+
+// DirectSolver computes gravity exactly by summing over every pair of stars.
+// It is O(N^2), so it is meant for small systems and for checking BarnesHutSolver.
+type DirectSolver struct {
+	workers int // goroutines summing forces; 0 uses every CPU, 1 runs serially
+}
+
+// parallelChunk is the number of stars a worker takes at a time. Handing out small chunks
+// keeps the workers evenly loaded when some stars need much deeper tree walks than others.
+const parallelChunk = 64
+
+// ComputeAccelerations builds a QuadTree of u and walks it once per star, spreading the stars over
+// solver.workers goroutines. Each walk only reads the tree, and every star's sum is done in the
+// same order by one goroutine, so the result doesn't depend on the number of workers.
+// It records the number of collapsed tree leaves on u.
+func (solver *BarnesHutSolver) ComputeAccelerations(u *Universe, accelerations []OrderedPair) {
+	params := ForceParameters{
+		theta:      solver.theta,
+		softening:  u.softening,
+		quadrupole: solver.quadrupole,
+		criterion:  solver.criterion,
+		tolerance:  solver.tolerance,
+	}
+
+	if solver.builder == TreeBuilderMorton {
+		solver.flat.needMoments = solver.criterion == CriterionSalmonWarren
+		refitted := solver.sinceBuild > 0 && solver.sinceBuild <= solver.reuseSteps &&
+			solver.flat.Refit(u.stars, solver.refitTolerance)
+		if refitted {
+			solver.sinceBuild++
+		} else {
+			solver.flat.Build(u.stars, solver.leafCapacity)
+			solver.sinceBuild = 1
+		}
+		u.collapsedLeaves = solver.flat.collapsedLeaves
+		if solver.workers == 1 {
+			// no goroutines, so no closure to allocate
+			for i, s := range u.stars {
+				accelerations[i] = FlatAcceleration(&solver.flat, s, params)
+			}
+			return
+		}
+		ParallelFor(len(u.stars), solver.workers, func(i int) {
+			accelerations[i] = FlatAcceleration(&solver.flat, u.stars[i], params)
+		})
+		return
+	}
+
+	tree := GenerateQuadTree(u, solver.leafCapacity, solver.buildDepth)
+	u.collapsedLeaves = tree.collapsedLeaves
+	ParallelFor(len(u.stars), solver.workers, func(i int) {
+		accelerations[i] = UpdateAcceleration(tree.root, u.stars[i], params)
+	})
+}
+
+// ComputeAccelerations sums the softened force of every other star on each star of u,
+// spreading the stars over solver.workers goroutines.
+func (solver DirectSolver) ComputeAccelerations(u *Universe, accelerations []OrderedPair) {
+	if solver.workers == 1 {
+		// no goroutines, so no closure to allocate
+		for i, s := range u.stars {
+			accelerations[i] = DirectAcceleration(u, s)
+		}
+		return
+	}
+	ParallelFor(len(u.stars), solver.workers, func(i int) {
+		accelerations[i] = DirectAcceleration(u, u.stars[i])
+	})
+}
+
+// DirectAcceleration returns the acceleration of s due to every other star of u.
+func DirectAcceleration(u *Universe, s *Star) OrderedPair {
+	var force OrderedPair
+	for _, other := range u.stars {
+		if other == s {
+			continue
+		}
+		f := CalcSoftenedForce(s, other, G, u.softening)
+		force.x += f.x
+		force.y += f.y
+	}
+	return OrderedPair{force.x / s.mass, force.y / s.mass}
+}
+
+// ParallelFor calls body(i) for every i from 0 to n-1 using the given number of goroutines
+// (0 means one per CPU). Indices are handed out in chunks, so body must not depend on call order.
+func ParallelFor(n, workers int, body func(i int)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	workers = min(workers, (n+parallelChunk-1)/parallelChunk)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			body(i)
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(next.Add(parallelChunk)) - parallelChunk
+				if start >= n {
+					return
+				}
+				for i := start; i < min(start+parallelChunk, n); i++ {
+					body(i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ForceError takes as input a universe, an approximate solver and a reference solver.
+// It returns the mean and the maximum relative error of the approximate accelerations,
+// |a_approx - a_ref| / |a_ref|, over all stars with a nonzero reference acceleration.
+func ForceError(u *Universe, approx, reference ForceSolver) (float64, float64) {
+	got := make([]OrderedPair, len(u.stars))
+	want := make([]OrderedPair, len(u.stars))
+	approx.ComputeAccelerations(u, got)
+	reference.ComputeAccelerations(u, want)
+
+	sum, worst := 0.0, 0.0
+	count := 0
+	for i := range want {
+		norm := math.Hypot(want[i].x, want[i].y)
+		if norm == 0 {
+			continue
+		}
+		err := math.Hypot(got[i].x-want[i].x, got[i].y-want[i].y) / norm
+		sum += err
+		worst = math.Max(worst, err)
+		count++
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return sum / float64(count), worst
+}
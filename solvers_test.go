@@ -0,0 +1,97 @@
+package main
+
+import (
+    "math"
+    "math/rand"
+    "testing"
+)
+
+// randomUniverse returns a universe of n stars scattered over a width x width square.
+func randomUniverse(n int, width float64, seed int64) *Universe {
+    rng := rand.New(rand.NewSource(seed))
+    u := &Universe{width: width}
+    for i := 0; i < n; i++ {
+        u.stars = append(u.stars, &Star{
+            position: OrderedPair{rng.Float64() * width, rng.Float64() * width},
+            velocity: OrderedPair{rng.NormFloat64(), rng.NormFloat64()},
+            mass:     solarMass * (0.5 + rng.Float64()),
+        })
+    }
+    return u
+}
+
+func TestForceError(t *testing.T) {
+    u := randomUniverse(300, 1e18, 1)
+
+    // theta = 0 never approximates, so Barnes-Hut must agree with direct summation
+    mean, worst := ForceError(u, &BarnesHutSolver{theta: 0, leafCapacity: 1}, DirectSolver{})
+    if worst > 1e-9 {
+        t.Errorf("theta 0: mean %v, max %v relative error", mean, worst)
+    }
+
+    mean, worst = ForceError(u, &BarnesHutSolver{theta: 0.5, leafCapacity: 4}, DirectSolver{})
+    if mean > 1e-2 || worst > 1e-1 {
+        t.Errorf("theta 0.5: mean %v, max %v relative error", mean, worst)
+    }
+}
+
+func TestQuadrupoleAccuracy(t *testing.T) {
+    u := randomUniverse(500, 1e18, 2)
+    for _, theta := range []float64{0.7, 1.0} {
+        monoMean, _ := ForceError(u, &BarnesHutSolver{theta: theta, leafCapacity: 1}, DirectSolver{})
+        quadMean, _ := ForceError(u, &BarnesHutSolver{theta: theta, leafCapacity: 1, quadrupole: true}, DirectSolver{})
+        if quadMean >= monoMean/2 {
+            t.Errorf("theta %v: quadrupole error %v not well below monopole error %v", theta, quadMean, monoMean)
+        }
+    }
+}
+
+func TestOpeningCriteria(t *testing.T) {
+    u := randomUniverse(400, 1e18, 3)
+    exact := make([]OrderedPair, len(u.stars))
+    DirectSolver{}.ComputeAccelerations(u, exact)
+
+    // the relative criterion compares against the previous step's acceleration
+    typical := 0.0
+    for i, s := range u.stars {
+        s.acceleration = exact[i]
+        typical += math.Hypot(exact[i].x, exact[i].y) / float64(len(u.stars))
+    }
+
+    // Salmon-Warren bounds the error of each accepted node, so a star errs by at most
+    // tolerance times the number of nodes it accepted (fewer than the number of stars)
+    tolerance := 1e-3 * typical
+    got := make([]OrderedPair, len(u.stars))
+    (&BarnesHutSolver{leafCapacity: 1, criterion: CriterionSalmonWarren, tolerance: tolerance}).ComputeAccelerations(u, got)
+    for i := range got {
+        if err := math.Hypot(got[i].x-exact[i].x, got[i].y-exact[i].y); err > tolerance*float64(len(u.stars)) {
+            t.Errorf("salmon-warren: star %d error %v exceeds bound", i, err)
+        }
+    }
+
+    solvers := map[string]ForceSolver{
+        "box edge": &BarnesHutSolver{theta: 0.5, leafCapacity: 1, criterion: CriterionBoxEdge},
+        "relative": &BarnesHutSolver{leafCapacity: 1, criterion: CriterionRelative, tolerance: 1e-3},
+    }
+    for name, solver := range solvers {
+        if mean, _ := ForceError(u, solver, DirectSolver{}); mean > 1e-2 {
+            t.Errorf("%s: mean relative error %v", name, mean)
+        }
+    }
+}
+
+func TestParallelForces(t *testing.T) {
+    u := randomUniverse(1000, 1e18, 4)
+    serial := make([]OrderedPair, len(u.stars))
+    (&BarnesHutSolver{theta: 0.7, leafCapacity: 4, quadrupole: true, workers: 1}).ComputeAccelerations(u, serial)
+
+    for _, workers := range []int{0, 2, 7, 16} {
+        got := make([]OrderedPair, len(u.stars))
+        (&BarnesHutSolver{theta: 0.7, leafCapacity: 4, quadrupole: true, workers: workers}).ComputeAccelerations(u, got)
+        for i := range got {
+            if got[i] != serial[i] {
+                t.Fatalf("%d workers: star %d got %v, serial gave %v", workers, i, got[i], serial[i])
+            }
+        }
+    }
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TrajectoryFormat selects how a TrajectoryWriter lays out its records.
+type TrajectoryFormat int
+
+const (
+	TrajectoryCSV       TrajectoryFormat = iota // one row per star per generation, after a header row
+	TrajectoryJSONLines                         // one JSON object per star per generation
+)
+
+// trajectoryFields are the columns of a trajectory record, in order.
+var trajectoryFields = []string{"step", "time", "star", "name", "x", "y", "vx", "vy", "ax", "ay", "mass"}
+
+// TrajectoryWriter streams the state of every star of the universes it is given to a CSV or
+// JSON Lines file, one record per star per universe. Its Visit method can be passed straight to
+// SimulateStream, SimulateInPlace or SimulateCheckpointed, whose stride selects the generations.
+// A star is identified by its index in the universe's star slice, and by its name if it has one.
+type TrajectoryWriter struct {
+	w      *bufio.Writer
+	format TrajectoryFormat
+	time   float64    // time step, to turn steps into times
+	units  UnitSystem // units of the numbers written
+	buf    []byte     // one record, reused
+	header bool       // whether the CSV header has been written
+	err    error      // first error met by Visit
+}
+
+// NewTrajectoryWriter takes as input a writer, a format, the time step of the run (in seconds)
+// and the unit system to write times, positions, velocities, accelerations and masses in, and
+// returns a TrajectoryWriter writing to it. Call Flush when the run is over.
+func NewTrajectoryWriter(w io.Writer, format TrajectoryFormat, time float64, units UnitSystem) *TrajectoryWriter {
+	return &TrajectoryWriter{w: bufio.NewWriter(w), format: format, time: time, units: units}
+}
+
+// TrajectoryFormatOf returns the format a trajectory file should have from its name:
+// JSON Lines for .jsonl and .ndjson files, CSV for anything else.
+func TrajectoryFormatOf(path string) TrajectoryFormat {
+	switch filepath.Ext(path) {
+	case ".jsonl", ".ndjson":
+		return TrajectoryJSONLines
+	}
+	return TrajectoryCSV
+}
+
+// Write writes one record for every star of u.
+func (tw *TrajectoryWriter) Write(u *Universe) error {
+	if tw.format == TrajectoryCSV && !tw.header {
+		tw.buf = tw.buf[:0]
+		for i, field := range trajectoryFields {
+			if i > 0 {
+				tw.buf = append(tw.buf, ',')
+			}
+			tw.buf = append(tw.buf, field...)
+		}
+		tw.buf = append(tw.buf, '\n')
+		if _, err := tw.w.Write(tw.buf); err != nil {
+			return err
+		}
+		tw.header = true
+	}
+
+	for i, s := range u.stars {
+		us := tw.units
+		values := [...]float64{
+			us.FromSI(s.position.x, DimLength), us.FromSI(s.position.y, DimLength),
+			us.FromSI(s.velocity.x, DimVelocity), us.FromSI(s.velocity.y, DimVelocity),
+			us.FromSI(s.acceleration.x, DimAcceleration), us.FromSI(s.acceleration.y, DimAcceleration),
+			us.FromSI(s.mass, DimMass),
+		}
+		clock := us.FromSI(float64(u.step)*tw.time, DimTime)
+		tw.buf = tw.buf[:0]
+		if tw.format == TrajectoryJSONLines {
+			tw.buf = append(tw.buf, `{"step":`...)
+			tw.buf = strconv.AppendInt(tw.buf, int64(u.step), 10)
+			tw.buf = append(tw.buf, `,"time":`...)
+			tw.buf = appendJSONFloat(tw.buf, clock)
+			tw.buf = append(tw.buf, `,"star":`...)
+			tw.buf = strconv.AppendInt(tw.buf, int64(i), 10)
+			tw.buf = append(tw.buf, `,"name":`...)
+			tw.buf = appendJSONString(tw.buf, s.name)
+			for k, v := range values {
+				tw.buf = append(tw.buf, `,"`...)
+				tw.buf = append(tw.buf, trajectoryFields[4+k]...)
+				tw.buf = append(tw.buf, `":`...)
+				tw.buf = appendJSONFloat(tw.buf, v)
+			}
+			tw.buf = append(tw.buf, "}\n"...)
+		} else {
+			tw.buf = strconv.AppendInt(tw.buf, int64(u.step), 10)
+			tw.buf = append(tw.buf, ',')
+			tw.buf = strconv.AppendFloat(tw.buf, clock, 'g', -1, 64)
+			tw.buf = append(tw.buf, ',')
+			tw.buf = strconv.AppendInt(tw.buf, int64(i), 10)
+			tw.buf = append(tw.buf, ',')
+			tw.buf = appendCSVField(tw.buf, s.name)
+			for _, v := range values {
+				tw.buf = append(tw.buf, ',')
+				tw.buf = strconv.AppendFloat(tw.buf, v, 'g', -1, 64)
+			}
+			tw.buf = append(tw.buf, '\n')
+		}
+		if _, err := tw.w.Write(tw.buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Visit is Write for use as a simulation's visit function. After the first error it writes
+// nothing more, and Flush reports the error.
+func (tw *TrajectoryWriter) Visit(u *Universe) {
+	if tw.err == nil {
+		tw.err = tw.Write(u)
+	}
+}
+
+// Flush writes out any buffered records and returns the first error met along the way. It may be
+// called during the run too, after which Visit writes nothing more if it failed.
+func (tw *TrajectoryWriter) Flush() error {
+	if tw.err == nil {
+		tw.err = tw.w.Flush()
+	}
+	return tw.err
+}
+
+// appendJSONFloat appends a number to a JSON record. JSON has no NaN or infinity, so those become null.
+func appendJSONFloat(b []byte, v float64) []byte {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return append(b, "null"...)
+	}
+	return strconv.AppendFloat(b, v, 'g', -1, 64)
+}
+
+// appendJSONString appends a string to a JSON record, quoted and escaped.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < 0x20:
+			b = append(b, `\u00`...)
+			b = append(b, "0123456789abcdef"[c>>4], "0123456789abcdef"[c&0xf])
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(b, '"')
+}
+
+// appendCSVField appends a string to a CSV row, quoting it if it holds a comma, quote or line break.
+func appendCSVField(b []byte, s string) []byte {
+	if !strings.ContainsAny(s, ",\"\r\n") {
+		return append(b, s...)
+	}
+	b = append(b, '"')
+	b = append(b, strings.ReplaceAll(s, `"`, `""`)...)
+	return append(b, '"')
+}
+
+// SaveTrajectory writes the trajectory of every universe of a run with time step `time` to path,
+// in the unit system us, as JSON Lines or CSV depending on its extension (see TrajectoryFormatOf).
+func SaveTrajectory(timePoints []*Universe, time float64, us UnitSystem, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	tw := NewTrajectoryWriter(f, TrajectoryFormatOf(path), time, us)
+	for _, u := range timePoints {
+		tw.Visit(u)
+	}
+	if err := tw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AppendTrajectory opens the trajectory file at path of a run interrupted after generation step,
+// for the run resumed from there to carry on. It drops the records of later generations, which the
+// resumed run writes again, and any record cut short, and returns a TrajectoryWriter appending to
+// what is left, with the times of a run with time step `time` in the unit system us. The caller
+// closes the returned file after flushing the writer.
+func AppendTrajectory(path string, step int, time float64, us UnitSystem) (*TrajectoryWriter, *os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	format := TrajectoryFormatOf(path)
+	size, err := trajectoryPrefix(f, format, step)
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	tw := NewTrajectoryWriter(f, format, time, us)
+	tw.header = size > 0
+	return tw, f, nil
+}
+
+// trajectoryPrefix returns the length of the start of a trajectory that holds its whole records
+// up to generation step.
+func trajectoryPrefix(r io.Reader, format TrajectoryFormat, step int) (int64, error) {
+	br := bufio.NewReader(r)
+	var read, kept int64
+	quoted := false // whether the lines read so far end inside a quoted CSV field
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			// a last line without its line break was cut short
+			return kept, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !quoted && recordStep(line, format) > step {
+			return kept, nil
+		}
+		read += int64(len(line))
+		if format == TrajectoryCSV && strings.Count(line, `"`)%2 == 1 {
+			quoted = !quoted
+		}
+		if !quoted {
+			kept = read
+		}
+	}
+}
+
+// recordStep returns the generation of the trajectory record starting with line, or -1 for the
+// CSV header.
+func recordStep(line string, format TrajectoryFormat) int {
+	if format == TrajectoryJSONLines {
+		line = strings.TrimPrefix(line, `{"step":`)
+	}
+	number, _, _ := strings.Cut(line, ",")
+	step, err := strconv.Atoi(number)
+	if err != nil {
+		return -1
+	}
+	return step
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "math"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestTrajectoryWriter(t *testing.T) {
+    initial := &Universe{width: 1e12, stars: []*Star{
+        {name: "Sun", position: OrderedPair{0, 0}, mass: 2e30},
+        {name: `Earth, "third rock"`, position: OrderedPair{1.5e11, 0}, velocity: OrderedPair{0, 3e4}, mass: 6e24},
+    }}
+
+    var csvOut, jsonOut bytes.Buffer
+    asCSV := NewTrajectoryWriter(&csvOut, TrajectoryCSV, 3600, SIUnits)
+    asJSON := NewTrajectoryWriter(&jsonOut, TrajectoryJSONLines, 3600, SIUnits)
+    var kept []*Universe
+    SimulateStream(initial, 10, 3600, DirectSolver{}, VerletIntegrator{}, 5, func(u *Universe) {
+        kept = append(kept, u)
+        asCSV.Visit(u)
+        asJSON.Visit(u)
+    })
+    if err := asCSV.Flush(); err != nil {
+        t.Fatal(err)
+    }
+    if err := asJSON.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    // generations 0, 5 and 10, two stars each; numbers survive the round trip exactly
+    rows, err := csv.NewReader(&csvOut).ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(rows) != 7 || strings.Join(rows[0], ",") != "step,time,star,name,x,y,vx,vy,ax,ay,mass" {
+        t.Fatalf("CSV has %d rows, header %v", len(rows), rows[0])
+    }
+    if got := strings.Join(rows[6][:4], "|"); got != `10|36000|1|Earth, "third rock"` {
+        t.Errorf("last CSV row starts %s", got)
+    }
+    lines := strings.Split(strings.TrimSpace(jsonOut.String()), "\n")
+    if len(lines) != 6 {
+        t.Fatalf("JSON Lines has %d records, want 6", len(lines))
+    }
+    var record map[string]any
+    if err := json.Unmarshal([]byte(lines[5]), &record); err != nil {
+        t.Fatal(err)
+    }
+    want := kept[2].stars[1]
+    if record["name"] != want.name || record["step"] != 10.0 || record["x"] != want.position.x || record["vy"] != want.velocity.y || record["ax"] != want.acceleration.x {
+        t.Errorf("last record %v doesn't match star %v", record, *want)
+    }
+
+    // a NaN still gives valid JSON
+    jsonOut.Reset()
+    broken := NewTrajectoryWriter(&jsonOut, TrajectoryJSONLines, 1, SIUnits)
+    broken.Visit(&Universe{stars: []*Star{{position: OrderedPair{math.NaN(), 0}}}})
+    broken.Flush()
+    if !json.Valid(bytes.TrimSpace(jsonOut.Bytes())) {
+        t.Errorf("invalid JSON for a NaN position: %s", jsonOut.String())
+    }
+
+    // write errors come out of Flush
+    failing := NewTrajectoryWriter(failWriter{}, TrajectoryCSV, 1, SIUnits)
+    for i := 0; i < 100; i++ {
+        failing.Visit(kept[0])
+    }
+    if err := failing.Flush(); err == nil {
+        t.Error("no error from a failing writer")
+    }
+}
+
+func TestAppendTrajectory(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "trajectory.csv")
+    u := &Universe{stars: []*Star{{name: "two\nlines, \"quoted\"", mass: 1}, {name: "Sun", mass: 2}}}
+    write := func(tw *TrajectoryWriter, steps ...int) {
+        for _, step := range steps {
+            u.step = step
+            tw.Visit(u)
+        }
+        if err := tw.Flush(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    var want bytes.Buffer
+    write(NewTrajectoryWriter(&want, TrajectoryCSV, 1, SIUnits), 0, 5, 10, 15)
+
+    // a run stopped while writing generation 15 and resumed from generation 5 writes 10 and 15 again
+    var interrupted bytes.Buffer
+    write(NewTrajectoryWriter(&interrupted, TrajectoryCSV, 1, SIUnits), 0, 5, 10, 15)
+    if err := os.WriteFile(path, interrupted.Bytes()[:interrupted.Len()-20], 0o644); err != nil {
+        t.Fatal(err)
+    }
+    tw, f, err := AppendTrajectory(path, 5, 1, SIUnits)
+    if err != nil {
+        t.Fatal(err)
+    }
+    write(tw, 10, 15)
+    f.Close()
+    if got, _ := os.ReadFile(path); string(got) != want.String() {
+        t.Errorf("appended trajectory\n%s\nwant\n%s", got, want.String())
+    }
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The simulation itself always works in SI units with the constant G. A UnitSystem converts
+// values between SI and the units people write scenarios and read results in.
+
+// Dimension is the physical dimension of a quantity as powers of length, mass and time.
+type Dimension struct {
+	length, mass, time int
+}
+
+var (
+	DimNone         = Dimension{0, 0, 0}
+	DimLength       = Dimension{1, 0, 0}
+	DimMass         = Dimension{0, 1, 0}
+	DimTime         = Dimension{0, 0, 1}
+	DimVelocity     = Dimension{1, 0, -1}
+	DimAcceleration = Dimension{1, 0, -2}
+	DimG            = Dimension{3, -1, -2} // of the gravitational constant
+)
+
+// String writes a dimension the way units are written, e.g. "length/time^2".
+func (d Dimension) String() string {
+	var top, bottom []string
+	for _, part := range []struct {
+		name  string
+		power int
+	}{{"length", d.length}, {"mass", d.mass}, {"time", d.time}} {
+		name := part.name
+		if p := part.power; p > 1 || p < -1 {
+			name += "^" + strconv.Itoa(max(p, -p))
+		}
+		if part.power > 0 {
+			top = append(top, name)
+		} else if part.power < 0 {
+			bottom = append(bottom, name)
+		}
+	}
+	s := strings.Join(top, "*")
+	if s == "" {
+		s = "1"
+	}
+	if len(bottom) > 0 {
+		s += "/" + strings.Join(bottom, "/")
+	}
+	return s
+}
+
+// Unit is a named unit: its size in SI units and its dimension.
+type Unit struct {
+	size      float64
+	dimension Dimension
+}
+
+const (
+	astronomicalUnit = 1.495978707e11        // m
+	parsec           = 3.0856775814913673e16 // m
+	julianYear       = 3.15576e7             // s
+)
+
+// namedUnits are the units ParseQuantity knows, by name. Compound units such as km/s and m/s^2
+// are built from them.
+var namedUnits = map[string]Unit{
+	"m":   {1, DimLength},
+	"km":  {1e3, DimLength},
+	"AU":  {astronomicalUnit, DimLength},
+	"ly":  {9.4607304725808e15, DimLength},
+	"pc":  {parsec, DimLength},
+	"kpc": {1e3 * parsec, DimLength},
+	"Mpc": {1e6 * parsec, DimLength},
+
+	"kg":     {1, DimMass},
+	"g":      {1e-3, DimMass},
+	"Mearth": {5.9722e24, DimMass},
+	"Mjup":   {1.89813e27, DimMass},
+	"Msun":   {solarMass, DimMass},
+
+	"s":   {1, DimTime},
+	"min": {60, DimTime},
+	"h":   {3600, DimTime},
+	"day": {86400, DimTime},
+	"yr":  {julianYear, DimTime},
+	"kyr": {1e3 * julianYear, DimTime},
+	"Myr": {1e6 * julianYear, DimTime},
+	"Gyr": {1e9 * julianYear, DimTime},
+}
+
+// UnitSystem is a choice of units of length, mass and time, each given by its size in SI units.
+type UnitSystem struct {
+	name               string
+	length, mass, time float64
+}
+
+var (
+	SIUnits           = UnitSystem{"si", 1, 1, 1}
+	AstronomicalUnits = UnitSystem{"astronomical", astronomicalUnit, solarMass, julianYear}      // AU, solar masses, years
+	GalacticUnits     = UnitSystem{"galactic", 1e3 * parsec, 1e10 * solarMass, 1e6 * julianYear} // kpc, 1e10 solar masses, Myr
+)
+
+// NBodyUnits takes as input a mass and a length in SI units and returns the N-body unit system
+// built on them, whose unit of time makes G = 1.
+func NBodyUnits(mass, length float64) UnitSystem {
+	return UnitSystem{"nbody", length, mass, math.Sqrt(length * length * length / (G * mass))}
+}
+
+// UnitSystemByName returns the fixed unit system with the given name: si, astronomical or galactic.
+// N-body units need a mass and length, so they are made with NBodyUnits instead.
+func UnitSystemByName(name string) (UnitSystem, bool) {
+	for _, us := range []UnitSystem{SIUnits, AstronomicalUnits, GalacticUnits} {
+		if strings.EqualFold(name, us.name) {
+			return us, true
+		}
+	}
+	return UnitSystem{}, false
+}
+
+// Scale returns the size in SI units of one unit of the given dimension in this system.
+func (us UnitSystem) Scale(d Dimension) float64 {
+	return math.Pow(us.length, float64(d.length)) * math.Pow(us.mass, float64(d.mass)) * math.Pow(us.time, float64(d.time))
+}
+
+// ToSI converts a value of the given dimension from this system to SI units.
+func (us UnitSystem) ToSI(v float64, d Dimension) float64 {
+	return v * us.Scale(d)
+}
+
+// FromSI converts a value of the given dimension from SI units to this system.
+func (us UnitSystem) FromSI(v float64, d Dimension) float64 {
+	return v / us.Scale(d)
+}
+
+// G returns the gravitational constant in this system (1 for N-body units, up to rounding).
+func (us UnitSystem) G() float64 {
+	return us.FromSI(G, DimG)
+}
+
+// ParseUnit reads a unit such as "Myr", "km/s" or "m/s^2" and returns its size in SI units and
+// its dimension. Units are multiplied with '*' and divided with '/', and may be raised to a power.
+func ParseUnit(s string) (Unit, error) {
+	unit := Unit{size: 1}
+	sign := 1
+	rest := strings.TrimSpace(s)
+	for rest != "" {
+		i := strings.IndexAny(rest, "*/")
+		if i < 0 {
+			i = len(rest)
+		}
+		name, power := strings.TrimSpace(rest[:i]), 1
+		if base, exponent, ok := strings.Cut(name, "^"); ok {
+			p, err := strconv.Atoi(exponent)
+			if err != nil {
+				return Unit{}, fmt.Errorf("bad power in unit %q", s)
+			}
+			name, power = base, p
+		}
+		u, ok := namedUnits[name]
+		if !ok {
+			return Unit{}, fmt.Errorf("unknown unit %q", name)
+		}
+		power *= sign
+		unit.size *= math.Pow(u.size, float64(power))
+		unit.dimension.length += u.dimension.length * power
+		unit.dimension.mass += u.dimension.mass * power
+		unit.dimension.time += u.dimension.time * power
+
+		if i == len(rest) {
+			break
+		}
+		sign = 1
+		if rest[i] == '/' {
+			sign = -1
+		}
+		rest = rest[i+1:]
+	}
+	return unit, nil
+}
+
+// splitQuantity splits a quantity such as "30 km/s" or "2e16s" into its number and its unit,
+// either of which may be empty. The number is the longest prefix that reads as one.
+func splitQuantity(s string) (number, unit string) {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && strings.IndexByte("0123456789.eE+-", s[end]) >= 0 {
+		end++
+	}
+	for end > 0 {
+		if _, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			break
+		}
+		end--
+	}
+	return s[:end], strings.TrimSpace(s[end:])
+}
+
+// ParseQuantity reads a quantity of the given dimension, such as "1 Myr", "2e16s", "30 km/s" or
+// "kpc", and returns it in SI units. A bare number is taken to be in the unit system us, and a bare
+// unit means one of it. It returns an error if the quantity has the wrong dimension.
+func ParseQuantity(s string, d Dimension, us UnitSystem) (float64, error) {
+	s = strings.TrimSpace(s)
+	number, unitName := splitQuantity(s)
+	value := 1.0
+	if number != "" {
+		value, _ = strconv.ParseFloat(number, 64)
+	}
+	if unitName == "" {
+		if number == "" {
+			return 0, fmt.Errorf("empty quantity")
+		}
+		return us.ToSI(value, d), nil
+	}
+
+	unit, err := ParseUnit(unitName)
+	if err != nil {
+		return 0, fmt.Errorf("quantity %q: %w", s, err)
+	}
+	if unit.dimension != d {
+		return 0, fmt.Errorf("quantity %q has dimension %v, want %v", s, unit.dimension, d)
+	}
+	return value * unit.size, nil
+}
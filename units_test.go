@@ -0,0 +1,80 @@
+package main
+
+import (
+    "math"
+    "strings"
+    "testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+    tests := []struct {
+        input string
+        d     Dimension
+        us    UnitSystem
+        want  float64
+    }{
+        {"2e16", DimTime, SIUnits, 2e16},
+        {"2e16 s", DimTime, GalacticUnits, 2e16},
+        {"1 Myr", DimTime, SIUnits, 1e6 * julianYear},
+        {"1.5", DimTime, GalacticUnits, 1.5e6 * julianYear},
+        {"kpc", DimLength, SIUnits, 1e3 * parsec},
+        {"1e10Msun", DimMass, SIUnits, 1e10 * solarMass},
+        {"30 km/s", DimVelocity, SIUnits, 3e4},
+        {"1 AU/yr", DimVelocity, SIUnits, astronomicalUnit / julianYear},
+        {"9.8 m/s^2", DimAcceleration, SIUnits, 9.8},
+        {"-4.5 km", DimLength, SIUnits, -4500},
+    }
+    for _, test := range tests {
+        got, err := ParseQuantity(test.input, test.d, test.us)
+        if err != nil || math.Abs(got-test.want) > 1e-12*math.Abs(test.want) {
+            t.Errorf("ParseQuantity(%q) = %v, %v, want %v", test.input, got, err, test.want)
+        }
+    }
+
+    for input, want := range map[string]string{
+        "1 Msun":    "has dimension mass, want time",
+        "3 km/s":    "has dimension length/time, want time",
+        "2 parsecs": "unknown unit",
+        "":          "empty quantity",
+    } {
+        _, err := ParseQuantity(input, DimTime, SIUnits)
+        if err == nil || !strings.Contains(err.Error(), want) {
+            t.Errorf("ParseQuantity(%q): got error %v, want one containing %q", input, err, want)
+        }
+    }
+}
+
+func TestUnitSystems(t *testing.T) {
+    nbody := NBodyUnits(1e10*solarMass, 1e3*parsec)
+    if g := nbody.G(); math.Abs(g-1) > 1e-12 {
+        t.Errorf("G in N-body units = %v, want 1", g)
+    }
+    // the Earth goes round the Sun in a year at 1 AU, so G is about 4 pi^2 in astronomical units
+    if g := AstronomicalUnits.G(); math.Abs(g/(4*math.Pi*math.Pi)-1) > 1e-3 {
+        t.Errorf("G in astronomical units = %v, want about 4 pi^2", g)
+    }
+    for _, us := range []UnitSystem{SIUnits, AstronomicalUnits, GalacticUnits, nbody} {
+        if v := us.ToSI(us.FromSI(1.234e20, DimVelocity), DimVelocity); math.Abs(v/1.234e20-1) > 1e-15 {
+            t.Errorf("%s: velocity came back as %v", us.name, v)
+        }
+    }
+
+    // a body file in N-body units reads back as the same universe (its G line is checked on the way)
+    u, err := LoadBodies("jupiterMoons.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var buf strings.Builder
+    if err := WriteBodiesIn(&buf, u, NBodyUnits(u.stars[0].mass, 1e9)); err != nil {
+        t.Fatal(err)
+    }
+    back, err := ReadBodies(strings.NewReader(buf.String()))
+    if err != nil {
+        t.Fatalf("%v in\n%s", err, buf.String())
+    }
+    for i, s := range back.stars {
+        if math.Abs(s.position.x/u.stars[i].position.x-1) > 1e-14 || math.Abs(s.mass/u.stars[i].mass-1) > 1e-14 {
+            t.Errorf("body %d read back as %+v, want %+v", i, *s, *u.stars[i])
+        }
+    }
+}